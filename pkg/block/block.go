@@ -1,33 +1,124 @@
 package block
 
 import (
-	"bufio"
 	"fmt"
-	"github.com/pkg/errors"
-	"os/exec"
+	"io/ioutil"
 	"path"
+	"path/filepath"
 	"strings"
+
+	"github.com/godarch/darch/pkg/utils"
 )
 
-// GetBlockDeviceForPath Get the block device for the given path
-func GetBlockDeviceForPath(path string) (string, error) {
-	if len(path) == 0 {
-		return "", fmt.Errorf("path required")
+var (
+	// sysDevBlockPath Where the kernel exposes block devices by their major:minor.
+	sysDevBlockPath = "/sys/dev/block"
+)
+
+// Device The block device a path is stored on, and how it is mounted.
+type Device struct {
+	// Path The block device, such as /dev/sda2.
+	Path string
+	// MountPoint Where the filesystem is mounted.
+	MountPoint string
+	FSType     string
+	// Root The path within the filesystem that is mounted at MountPoint.
+	Root string
+	// Subvolume The btrfs subvolume that is mounted, if any.
+	Subvolume string
+	// Dataset The ZFS dataset that is mounted, if any. ZFS datasets have no single block device, so Path is empty.
+	Dataset  string
+	UUID     string
+	PARTUUID string
+	Major    int
+	Minor    int
+	// RelativePath The path that was looked up, relative to the root of the filesystem.
+	// This includes the btrfs subvolume (or bind mount source), since that is how grub sees it.
+	RelativePath string
+}
+
+// GetDeviceForPath Get the block device, and how it is mounted, for the given path.
+func GetDeviceForPath(p string) (Device, error) {
+	result := Device{}
+
+	if len(p) == 0 {
+		return result, fmt.Errorf("path required")
 	}
 
-	result, err := runCommand("df", "--output=source", path)
+	absolutePath, err := filepath.Abs(p)
 	if err != nil {
-		return "", err
+		return result, err
+	}
+	absolutePath, err = filepath.EvalSymlinks(absolutePath)
+	if err != nil {
+		return result, err
+	}
+
+	mounts, err := GetMounts()
+	if err != nil {
+		return result, err
+	}
+
+	m, err := findMountForPath(mounts, absolutePath)
+	if err != nil {
+		return result, err
 	}
 
-	if len(result) == 2 {
-		if result[1] == "-" {
-			return "", fmt.Errorf("error getting block device for %s, possibly not on block device", path)
+	result.MountPoint = m.MountPoint
+	result.FSType = m.FSType
+	result.Root = m.Root
+	result.Major = m.Major
+	result.Minor = m.Minor
+	result.RelativePath = getPathRelativeToMount(m, absolutePath)
+
+	if m.FSType == "zfs" {
+		result.Dataset = m.Source
+		result.UUID, err = getZFSPoolGUID(getZFSPool(m.Source))
+		if err != nil {
+			return result, err
 		}
-		return result[1], nil
+		return result, nil
+	}
+
+	result.Path, err = getDevicePathForMount(m)
+	if err != nil {
+		return result, fmt.Errorf("error getting block device for %s, possibly not on block device", p)
+	}
+
+	result.UUID, err = GetUUIDForBlockDevice(result.Path)
+	if err != nil {
+		return result, err
+	}
+
+	result.PARTUUID, err = lookupDiskLink("by-partuuid", result.Path)
+	if err != nil {
+		return result, err
+	}
+
+	result.Subvolume = m.Subvolume()
+
+	return result, nil
+}
+
+// MountOptions The options needed to mount the filesystem so that RelativePath resolves.
+// For btrfs, RelativePath includes the subvolume, so the top level subvolume must be mounted.
+func (device Device) MountOptions() string {
+	if device.FSType == "btrfs" {
+		return "subvolid=5"
 	}
+	return ""
+}
 
-	return "", errors.Wrap(fmt.Errorf(strings.Join(result, " ")), "error running df")
+// GetBlockDeviceForPath Get the block device for the given path
+func GetBlockDeviceForPath(path string) (string, error) {
+	device, err := GetDeviceForPath(path)
+	if err != nil {
+		return "", err
+	}
+	if len(device.Path) == 0 {
+		return "", fmt.Errorf("%s is on %s, which has no block device", path, device.Dataset)
+	}
+	return device.Path, nil
 }
 
 // GetUUIDForBlockDevice Get the UUID for the given block device.
@@ -36,63 +127,57 @@ func GetUUIDForBlockDevice(blockDevice string) (string, error) {
 		return "", fmt.Errorf("block device required")
 	}
 
-	result, err := runCommand("blkid", blockDevice)
+	uuid, err := lookupDiskLink("by-uuid", blockDevice)
 	if err != nil {
 		return "", err
 	}
-
-	if len(result) == 1 {
-		values := strings.Split(result[0], " ")
-		for _, value := range values {
-			if strings.HasPrefix(value, "UUID=\"") {
-				return value[6 : len(value)-1], nil
-			}
-		}
+	if len(uuid) > 0 {
+		return uuid, nil
 	}
 
-	return "", errors.Wrap(fmt.Errorf(strings.Join(result, " ")), "error running blkid")
+	// No udev, or udev hasn't gotten to this device yet.
+	return readSuperblockUUID(blockDevice)
 }
 
 // GetPathRelativeToBlockDevice Give a full path to your system, and it will return it's path, relative to the device it is hosted on.
 // For example, if a device is mounted on "/test/mount" and you invoke this method with "/test/mount/with/this/file",
-// then you will get /with/this/file returned.
+// then you will get /with/this/file returned. If the mount is a btrfs subvolume (or a bind mount), the path
+// includes the subvolume, since that is how the filesystem is seen by grub.
 func GetPathRelativeToBlockDevice(p string) (string, error) {
-	if len(p) == 0 {
-		return "", fmt.Errorf("path required")
-	}
-
-	result, err := runCommand("df", p, "--output=target")
+	device, err := GetDeviceForPath(p)
 	if err != nil {
 		return "", err
 	}
-
-	if len(result) == 2 {
-		return path.Join("/", p[len(result[1]):]), nil
-	}
-
-	return "", nil
+	return device.RelativePath, nil
 }
 
-func runCommand(name string, args ...string) ([]string, error) {
-	cmd := exec.Command(name, args...)
-	cmdOut, _ := cmd.StdoutPipe()
-
-	result := []string{}
-
-	err := cmd.Start()
-	if err != nil {
-		return result, err
+// getPathRelativeToMount Get the path within the filesystem for a path under the given mount.
+func getPathRelativeToMount(m Mount, p string) string {
+	relative := "/"
+	if m.MountPoint == "/" {
+		relative = p
+	} else if len(p) > len(m.MountPoint) {
+		relative = p[len(m.MountPoint):]
 	}
+	return path.Join("/", m.Root, relative)
+}
 
-	scanner := bufio.NewScanner(cmdOut)
-	for scanner.Scan() {
-		result = append(result, scanner.Text())
+// getDevicePathForMount Get the block device backing a mount.
+func getDevicePathForMount(m Mount) (string, error) {
+	if strings.HasPrefix(m.Source, "/dev/") && utils.FileExists(m.Source) && m.Source != "/dev/root" {
+		return m.Source, nil
 	}
 
-	err = cmd.Wait()
+	// Sources such as /dev/root don't exist on disk, ask the kernel for the name instead.
+	uevent, err := ioutil.ReadFile(path.Join(sysDevBlockPath, fmt.Sprintf("%d:%d", m.Major, m.Minor), "uevent"))
 	if err != nil {
-		return result, err
+		return "", err
+	}
+	for _, line := range strings.Split(string(uevent), "\n") {
+		if strings.HasPrefix(line, "DEVNAME=") {
+			return path.Join("/dev", line[len("DEVNAME="):]), nil
+		}
 	}
 
-	return result, nil
+	return "", fmt.Errorf("no block device for %s", m.MountPoint)
 }
@@ -1,6 +1,9 @@
 package block
 
 import (
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 )
 
@@ -36,3 +39,105 @@ func TestRelative(t *testing.T) {
 		t.Fatal("empty rel")
 	}
 }
+
+const testMountInfo = `22 1 8:2 / / rw,relatime shared:1 - ext4 /dev/sda2 rw
+25 22 8:1 / /boot rw,relatime shared:2 - vfat /dev/sda1 rw,fmask=0022
+30 22 0:45 /@var /var rw,relatime shared:3 - btrfs /dev/sdb1 rw,space_cache,subvolid=257,subvol=/@var
+31 22 8:3 / /mnt/my\040disk rw,relatime - ext4 /dev/sda3 rw
+32 22 8:3 /some/dir /srv/bound rw,relatime - ext4 /dev/sda3 rw
+33 22 0:50 / /var/lib/containers rw,relatime - overlay overlay rw,lowerdir=/a,upperdir=/b,workdir=/c
+`
+
+func TestParseMountInfo(t *testing.T) {
+	mounts, err := parseMountInfo(strings.NewReader(testMountInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 6 {
+		t.Fatalf("expected 6 mounts, got %d", len(mounts))
+	}
+
+	btrfs := mounts[2]
+	if btrfs.ID != 30 || btrfs.ParentID != 22 || btrfs.Major != 0 || btrfs.Minor != 45 {
+		t.Fatalf("unexpected ids for %+v", btrfs)
+	}
+	if btrfs.Root != "/@var" || btrfs.MountPoint != "/var" || btrfs.FSType != "btrfs" || btrfs.Source != "/dev/sdb1" {
+		t.Fatalf("unexpected btrfs mount %+v", btrfs)
+	}
+	if btrfs.Subvolume() != "/@var" {
+		t.Fatalf("unexpected subvolume %s", btrfs.Subvolume())
+	}
+	if mounts[0].Subvolume() != "" {
+		t.Fatal("ext4 should have no subvolume")
+	}
+
+	if mounts[3].MountPoint != "/mnt/my disk" {
+		t.Fatalf("unexpected escaped mount point %s", mounts[3].MountPoint)
+	}
+}
+
+func TestParseMountInfoInvalid(t *testing.T) {
+	_, err := parseMountInfo(strings.NewReader("22 1 8:2 / / rw,relatime shared:1\n"))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestPathRelativeToMount(t *testing.T) {
+	mounts, err := parseMountInfo(strings.NewReader(testMountInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path       string
+		mountPoint string
+		relative   string
+	}{
+		{"/etc/darch/grub.cfg", "/", "/etc/darch/grub.cfg"},
+		{"/boot", "/boot", "/"},
+		{"/bootstrap/file", "/", "/bootstrap/file"},
+		{"/var/lib/darch/stage/live", "/var", "/@var/lib/darch/stage/live"},
+		{"/mnt/my disk/stage", "/mnt/my disk", "/stage"},
+		{"/srv/bound/stage", "/srv/bound", "/some/dir/stage"},
+		{"/var/lib/containers/x", "/var/lib/containers", "/x"},
+	}
+
+	for _, test := range tests {
+		m, err := findMountForPath(mounts, test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.MountPoint != test.mountPoint {
+			t.Fatalf("expected %s to be on %s, got %s", test.path, test.mountPoint, m.MountPoint)
+		}
+		relative := getPathRelativeToMount(m, test.path)
+		if relative != test.relative {
+			t.Fatalf("expected %s to be %s on the device, got %s", test.path, test.relative, relative)
+		}
+	}
+}
+
+func TestSuperblockUUID(t *testing.T) {
+	f, err := ioutil.TempFile("", "darch-superblock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	image := make([]byte, 4096)
+	copy(image[1024+56:], []byte{0x53, 0xef})
+	copy(image[1024+104:], []byte{0xa8, 0x5b, 0xf1, 0xc9, 0x59, 0xa1, 0x4d, 0xba, 0x9d, 0xf9, 0x6f, 0xbb, 0xfa, 0x03, 0x46, 0x6c})
+	if _, err = f.Write(image); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	uuid, err := readSuperblockUUID(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uuid != "a85bf1c9-59a1-4dba-9df9-6fbbfa03466c" {
+		t.Fatalf("unexpected uuid %s", uuid)
+	}
+}
@@ -0,0 +1,70 @@
+package block
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// sysClassBlockPath Where the kernel exposes every block device (disks, partitions, device mapper, etc).
+	sysClassBlockPath = "/sys/class/block"
+)
+
+// IsEncrypted Returns true if the given block device is a dm-crypt mapping,
+// or is stacked on top of one (LVM on LUKS, etc).
+func IsEncrypted(blockDevice string) (bool, error) {
+	if len(blockDevice) == 0 {
+		return false, fmt.Errorf("block device required")
+	}
+
+	// Names like /dev/mapper/root are symlinks to the real device (/dev/dm-0).
+	resolved, err := filepath.EvalSymlinks(blockDevice)
+	if err != nil {
+		return false, err
+	}
+
+	return isEncrypted(path.Base(resolved), map[string]bool{})
+}
+
+func isEncrypted(name string, visited map[string]bool) (bool, error) {
+	if visited[name] {
+		return false, nil
+	}
+	visited[name] = true
+
+	deviceDir := path.Join(sysClassBlockPath, name)
+
+	// Device mapper targets created by cryptsetup have a uuid like "CRYPT-LUKS2-...".
+	dmUUID, err := ioutil.ReadFile(path.Join(deviceDir, "dm", "uuid"))
+	if err == nil {
+		if strings.HasPrefix(strings.TrimSpace(string(dmUUID)), "CRYPT-") {
+			return true, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	// Walk the devices this device is built on top of.
+	slaves, err := ioutil.ReadDir(path.Join(deviceDir, "slaves"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, slave := range slaves {
+		encrypted, err := isEncrypted(slave.Name(), visited)
+		if err != nil {
+			return false, err
+		}
+		if encrypted {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,134 @@
+package block
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func createSysClassBlock(t *testing.T, devices map[string]string, slaves map[string][]string) string {
+	dir, err := ioutil.TempDir("", "darch-block")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, dmUUID := range devices {
+		deviceDir := path.Join(dir, name)
+		if err = os.MkdirAll(path.Join(deviceDir, "slaves"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if len(dmUUID) > 0 {
+			if err = os.MkdirAll(path.Join(deviceDir, "dm"), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			if err = ioutil.WriteFile(path.Join(deviceDir, "dm", "uuid"), []byte(dmUUID+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, slave := range slaves[name] {
+			if err = os.MkdirAll(path.Join(deviceDir, "slaves", slave), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return dir
+}
+
+func TestIsEncrypted(t *testing.T) {
+	dir := createSysClassBlock(t, map[string]string{
+		"sda1": "",
+		"sda2": "",
+		"dm-0": "CRYPT-LUKS2-0c5fdd5b0e4e4b5c8d2ea8d3f14b8fd1-root",
+		"dm-1": "LVM-ZTWaNq5kFYDc1S3F2Sl6vYXpbYhq7b5Q",
+	}, map[string][]string{
+		"dm-0": {"sda2"},
+		"dm-1": {"dm-0"},
+	})
+	defer os.RemoveAll(dir)
+
+	previous := sysClassBlockPath
+	sysClassBlockPath = dir
+	defer func() { sysClassBlockPath = previous }()
+
+	expected := map[string]bool{
+		"sda1": false,
+		"sda2": false,
+		"dm-0": true,
+		"dm-1": true,
+	}
+	for name, value := range expected {
+		encrypted, err := isEncrypted(name, map[string]bool{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encrypted != value {
+			t.Fatalf("expected %t for %s, got %t", value, name, encrypted)
+		}
+	}
+}
+
+func TestGetDeviceStack(t *testing.T) {
+	dir := createSysClassBlock(t, map[string]string{
+		"sda2": "",
+		"dm-0": "CRYPT-LUKS2-0c5fdd5b0e4e4b5c8d2ea8d3f14b8fd1-root",
+		"dm-1": "LVM-ZTWaNq5kFYDc1S3F2Sl6vYXpbYhq7b5Q",
+	}, map[string][]string{
+		"dm-0": {"sda2"},
+		"dm-1": {"dm-0"},
+	})
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(path.Join(dir, "sda2", "partition"), []byte("2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "sda2", "dev"), []byte("8:2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	udevDir, err := ioutil.TempDir("", "darch-udev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(udevDir)
+	if err = ioutil.WriteFile(path.Join(udevDir, "b8:2"), []byte("S:disk/by-partuuid/x\nE:ID_PART_ENTRY_SCHEME=gpt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	previousSys, previousUdev := sysClassBlockPath, udevDataPath
+	sysClassBlockPath, udevDataPath = dir, udevDir
+	defer func() { sysClassBlockPath, udevDataPath = previousSys, previousUdev }()
+
+	stack := DeviceStack{}
+	if err = getDeviceStack("dm-1", &stack, map[string]bool{}); err != nil {
+		t.Fatal(err)
+	}
+	if !stack.LVM {
+		t.Fatal("expected lvm")
+	}
+	if len(stack.CryptoDisks) != 1 || stack.CryptoDisks[0].Type != "LUKS2" || stack.CryptoDisks[0].UUID != "0c5fdd5b0e4e4b5c8d2ea8d3f14b8fd1" {
+		t.Fatalf("unexpected crypto disks %+v", stack.CryptoDisks)
+	}
+	if len(stack.PartitionSchemes) != 1 || stack.PartitionSchemes[0] != "gpt" {
+		t.Fatalf("unexpected partition schemes %v", stack.PartitionSchemes)
+	}
+}
+
+func TestGetDeviceStackUnsupportedCrypt(t *testing.T) {
+	dir := createSysClassBlock(t, map[string]string{
+		"sda2": "",
+		"dm-0": "CRYPT-PLAIN-root",
+	}, map[string][]string{
+		"dm-0": {"sda2"},
+	})
+	defer os.RemoveAll(dir)
+
+	previous := sysClassBlockPath
+	sysClassBlockPath = dir
+	defer func() { sysClassBlockPath = previous }()
+
+	err := getDeviceStack("dm-0", &DeviceStack{}, map[string]bool{})
+	if err == nil || !strings.Contains(err.Error(), "unsupported crypt type PLAIN") {
+		t.Fatalf("expected an unsupported crypt type error, got %v", err)
+	}
+}
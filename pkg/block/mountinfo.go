@@ -0,0 +1,153 @@
+package block
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// mountInfoPath Where the kernel describes the mounts of our mount namespace.
+	mountInfoPath = "/proc/self/mountinfo"
+)
+
+// Mount A mounted filesystem, as described by /proc/self/mountinfo.
+type Mount struct {
+	ID       int
+	ParentID int
+	Major    int
+	Minor    int
+	// Root The path within the filesystem that is mounted. This is the subvolume
+	// for btrfs, or the source directory of a bind mount.
+	Root         string
+	MountPoint   string
+	Options      []string
+	FSType       string
+	Source       string
+	SuperOptions []string
+}
+
+// Subvolume The btrfs subvolume that is mounted, if any.
+func (m Mount) Subvolume() string {
+	if m.FSType != "btrfs" {
+		return ""
+	}
+	for _, option := range m.SuperOptions {
+		if strings.HasPrefix(option, "subvol=") {
+			return option[len("subvol="):]
+		}
+	}
+	return ""
+}
+
+// GetMounts Get all of the mounts in our mount namespace.
+func GetMounts() ([]Mount, error) {
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseMountInfo(f)
+}
+
+// parseMountInfo Parses the format of /proc/<pid>/mountinfo, described in proc(5).
+// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func parseMountInfo(r io.Reader) ([]Mount, error) {
+	result := []Mount{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 {
+			continue
+		}
+
+		fields := strings.Split(line, " ")
+
+		// The optional fields are terminated by a single "-".
+		separator := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				separator = i
+				break
+			}
+		}
+		if len(fields) < 7 || separator == -1 || len(fields) < separator+4 {
+			return result, fmt.Errorf("invalid mountinfo entry \"%s\"", line)
+		}
+
+		m := Mount{}
+		var err error
+		if m.ID, err = strconv.Atoi(fields[0]); err != nil {
+			return result, fmt.Errorf("invalid mountinfo entry \"%s\"", line)
+		}
+		if m.ParentID, err = strconv.Atoi(fields[1]); err != nil {
+			return result, fmt.Errorf("invalid mountinfo entry \"%s\"", line)
+		}
+		if _, err = fmt.Sscanf(fields[2], "%d:%d", &m.Major, &m.Minor); err != nil {
+			return result, fmt.Errorf("invalid mountinfo entry \"%s\"", line)
+		}
+		m.Root = unescapeMountInfo(fields[3])
+		m.MountPoint = unescapeMountInfo(fields[4])
+		m.Options = strings.Split(fields[5], ",")
+		m.FSType = fields[separator+1]
+		m.Source = unescapeMountInfo(fields[separator+2])
+		m.SuperOptions = strings.Split(fields[separator+3], ",")
+
+		result = append(result, m)
+	}
+
+	return result, scanner.Err()
+}
+
+// unescapeMountInfo Replaces the octal escapes (\040 for a space, etc) the kernel uses in mountinfo.
+func unescapeMountInfo(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+	var result strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) {
+			if code, err := strconv.ParseUint(value[i+1:i+4], 8, 8); err == nil {
+				result.WriteByte(byte(code))
+				i += 3
+				continue
+			}
+		}
+		result.WriteByte(value[i])
+	}
+	return result.String()
+}
+
+// findMountForPath Finds the mount that the given (absolute, symlink free) path lives on.
+func findMountForPath(mounts []Mount, p string) (Mount, error) {
+	found := false
+	result := Mount{}
+	for _, m := range mounts {
+		if !isPathUnder(p, m.MountPoint) {
+			continue
+		}
+		// Prefer the deepest mount point. When something is mounted
+		// over an existing mount point, the later entry wins.
+		if !found || len(m.MountPoint) >= len(result.MountPoint) {
+			result = m
+			found = true
+		}
+	}
+	if !found {
+		return result, fmt.Errorf("no mount found for %s", p)
+	}
+	return result, nil
+}
+
+// isPathUnder Returns true if p is the directory, or is within it.
+func isPathUnder(p string, directory string) bool {
+	if directory == "/" {
+		return true
+	}
+	return p == directory || strings.HasPrefix(p, directory+"/")
+}
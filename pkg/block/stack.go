@@ -0,0 +1,170 @@
+package block
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/godarch/darch/pkg/utils"
+)
+
+var (
+	// udevDataPath Where udev stores the properties it knows about each device.
+	udevDataPath = "/run/udev/data"
+)
+
+// CryptoDisk A dm-crypt mapping that a device is built on top of.
+type CryptoDisk struct {
+	// Type The type of the crypt device (LUKS1 or LUKS2).
+	Type string
+	// UUID The uuid of the crypt device, without dashes (as given to grub's "cryptomount -u").
+	UUID string
+}
+
+// DeviceStack The devices a block device is built on top of (LVM on LUKS on a GPT partition, etc).
+type DeviceStack struct {
+	CryptoDisks []CryptoDisk
+	LVM         bool
+	// PartitionSchemes The partition tables (gpt, dos) of the partitions at the bottom of the stack.
+	PartitionSchemes []string
+}
+
+// GetDeviceStack Get the devices the given block device is built on top of.
+func GetDeviceStack(blockDevice string) (DeviceStack, error) {
+	result := DeviceStack{}
+
+	if len(blockDevice) == 0 {
+		return result, fmt.Errorf("block device required")
+	}
+
+	resolved, err := filepath.EvalSymlinks(blockDevice)
+	if err != nil {
+		return result, err
+	}
+
+	err = getDeviceStack(path.Base(resolved), &result, map[string]bool{})
+	return result, err
+}
+
+func getDeviceStack(name string, stack *DeviceStack, visited map[string]bool) error {
+	if visited[name] {
+		return nil
+	}
+	visited[name] = true
+
+	deviceDir := path.Join(sysClassBlockPath, name)
+
+	dmUUID, err := ioutil.ReadFile(path.Join(deviceDir, "dm", "uuid"))
+	if err == nil {
+		value := strings.TrimSpace(string(dmUUID))
+		switch {
+		case strings.HasPrefix(value, "CRYPT-LUKS1-"), strings.HasPrefix(value, "CRYPT-LUKS2-"):
+			// CRYPT-LUKS2-0c5fdd5b0e4e4b5c8d2ea8d3f14b8fd1-root
+			parts := strings.SplitN(value, "-", 4)
+			if len(parts) < 3 || len(parts[2]) == 0 {
+				return fmt.Errorf("invalid dm uuid %s for %s", value, name)
+			}
+			stack.CryptoDisks = append(stack.CryptoDisks, CryptoDisk{
+				Type: parts[1],
+				UUID: strings.Replace(parts[2], "-", "", -1),
+			})
+		case strings.HasPrefix(value, "CRYPT-"):
+			// Plain dm-crypt (CRYPT-PLAIN-root) has no header, so there is no uuid for grub to find it by.
+			return fmt.Errorf("unsupported crypt type %s for %s, only LUKS1 and LUKS2 are supported", strings.SplitN(value, "-", 3)[1], name)
+		case strings.HasPrefix(value, "LVM-"):
+			stack.LVM = true
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	slaves, err := ioutil.ReadDir(path.Join(deviceDir, "slaves"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(slaves) == 0 {
+		// The bottom of the stack, a partition or a whole disk.
+		scheme, err := getPartitionScheme(name)
+		if err != nil {
+			return err
+		}
+		if len(scheme) > 0 && !utils.Contains(stack.PartitionSchemes, scheme) {
+			stack.PartitionSchemes = append(stack.PartitionSchemes, scheme)
+		}
+		return nil
+	}
+
+	for _, slave := range slaves {
+		err = getDeviceStack(slave.Name(), stack, visited)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getPartitionScheme Get the partition table type (gpt, dos) of the disk that a partition is on.
+// Returns an empty string if the device isn't a partition.
+func getPartitionScheme(name string) (string, error) {
+	deviceDir := path.Join(sysClassBlockPath, name)
+
+	if _, err := os.Stat(path.Join(deviceDir, "partition")); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	// Ask udev first.
+	dev, err := ioutil.ReadFile(path.Join(deviceDir, "dev"))
+	if err != nil {
+		return "", err
+	}
+	udevData, err := ioutil.ReadFile(path.Join(udevDataPath, "b"+strings.TrimSpace(string(dev))))
+	if err == nil {
+		for _, line := range strings.Split(string(udevData), "\n") {
+			if strings.HasPrefix(line, "E:ID_PART_ENTRY_SCHEME=") {
+				return line[len("E:ID_PART_ENTRY_SCHEME="):], nil
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	// No udev, look for the GPT header on the disk the partition belongs to.
+	// /sys/class/block/sda2 links to .../block/sda/sda2
+	resolved, err := filepath.EvalSymlinks(deviceDir)
+	if err != nil {
+		return "", err
+	}
+	return readPartitionScheme(path.Join("/dev", path.Base(path.Dir(resolved))))
+}
+
+// readPartitionScheme Reads the partition table type from the start of a disk.
+func readPartitionScheme(disk string) (string, error) {
+	f, err := os.Open(disk)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// The GPT header is in the second logical block, which is 4096 bytes in on 4Kn disks.
+	for _, offset := range []int64{512, 4096} {
+		header := make([]byte, 8)
+		if _, err = f.ReadAt(header, offset); err == nil && bytes.Equal(header, []byte("EFI PART")) {
+			return "gpt", nil
+		}
+	}
+
+	signature := make([]byte, 2)
+	if _, err = f.ReadAt(signature, 510); err == nil && bytes.Equal(signature, []byte{0x55, 0xaa}) {
+		return "dos", nil
+	}
+
+	return "", nil
+}
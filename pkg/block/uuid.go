@@ -0,0 +1,95 @@
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+var (
+	// devDiskPath Where udev creates the persistent names for block devices.
+	devDiskPath = "/dev/disk"
+)
+
+// superblock Where to find the uuid of a filesystem, when udev can't tell us.
+type superblock struct {
+	fsType      string
+	magicOffset int64
+	magic       []byte
+	uuidOffset  int64
+}
+
+var superblocks = []superblock{
+	{fsType: "ext", magicOffset: 1024 + 56, magic: []byte{0x53, 0xef}, uuidOffset: 1024 + 104},
+	{fsType: "btrfs", magicOffset: 0x10000 + 0x40, magic: []byte("_BHRfS_M"), uuidOffset: 0x10000 + 0x20},
+	{fsType: "xfs", magicOffset: 0, magic: []byte("XFSB"), uuidOffset: 32},
+}
+
+// lookupDiskLink Finds the name of the link in /dev/disk/<kind> that points to the given device.
+// Returns an empty string if there isn't one.
+func lookupDiskLink(kind string, blockDevice string) (string, error) {
+	resolvedDevice, err := filepath.EvalSymlinks(blockDevice)
+	if err != nil {
+		return "", err
+	}
+
+	links, err := ioutil.ReadDir(path.Join(devDiskPath, kind))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	for _, link := range links {
+		resolvedLink, err := filepath.EvalSymlinks(path.Join(devDiskPath, kind, link.Name()))
+		if err != nil {
+			// Dangling links are left behind while devices are removed.
+			continue
+		}
+		if resolvedLink == resolvedDevice {
+			return link.Name(), nil
+		}
+	}
+
+	return "", nil
+}
+
+// readSuperblockUUID Reads the filesystem uuid directly from the superblock of a device.
+func readSuperblockUUID(blockDevice string) (string, error) {
+	f, err := os.Open(blockDevice)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	for _, sb := range superblocks {
+		magic := make([]byte, len(sb.magic))
+		if _, err := f.ReadAt(magic, sb.magicOffset); err != nil {
+			continue
+		}
+		if !bytes.Equal(magic, sb.magic) {
+			continue
+		}
+		uuid := make([]byte, 16)
+		if _, err := f.ReadAt(uuid, sb.uuidOffset); err != nil {
+			return "", err
+		}
+		return formatUUID(uuid), nil
+	}
+
+	return "", fmt.Errorf("unknown filesystem on %s", blockDevice)
+}
+
+func formatUUID(uuid []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x",
+		binary.BigEndian.Uint32(uuid[0:4]),
+		binary.BigEndian.Uint16(uuid[4:6]),
+		binary.BigEndian.Uint16(uuid[6:8]),
+		binary.BigEndian.Uint16(uuid[8:10]),
+		uuid[10:16])
+}
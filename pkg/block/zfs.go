@@ -0,0 +1,28 @@
+package block
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// getZFSPool Get the pool a ZFS dataset belongs to.
+func getZFSPool(dataset string) string {
+	return strings.SplitN(dataset, "/", 2)[0]
+}
+
+// getZFSPoolGUID Get the guid of a ZFS pool, formatted the way grub expects it for "search --fs-uuid".
+func getZFSPoolGUID(pool string) (string, error) {
+	output, err := exec.Command("zpool", "get", "-H", "-p", "-o", "value", "guid", pool).Output()
+	if err != nil {
+		return "", fmt.Errorf("error getting the guid of zfs pool %s: %v", pool, err)
+	}
+
+	guid, err := strconv.ParseUint(strings.TrimSpace(string(output)), 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid guid for zfs pool %s: %v", pool, err)
+	}
+
+	return fmt.Sprintf("%016x", guid), nil
+}
@@ -2,9 +2,8 @@ package helpers
 
 import (
 	"fmt"
-	"strings"
 
-	"github.com/gobwas/glob"
+	"github.com/godarch/darch/pkg/hooks"
 	"github.com/godarch/darch/pkg/utils"
 	"github.com/urfave/cli"
 )
@@ -31,25 +30,14 @@ var globFirstMatchCommand = cli.Command{
 			return err
 		}
 
-		for _, line := range lines {
-			delimiterPosition := strings.LastIndex(line, "=")
-			if delimiterPosition == -1 {
-				return fmt.Errorf("invalid entry \"%s\"", line)
-			}
-
-			globPattern := line[:delimiterPosition]
-			globValue := line[delimiterPosition+1:]
-
-			if len(globPattern) == 0 {
-				return fmt.Errorf("invalid entry \"%s\"", line)
-			}
-
-			g := glob.MustCompile(globPattern)
+		match, matched, err := hooks.GlobConfigFirstMatch(lines, value)
+		if err != nil {
+			return err
+		}
 
-			if g.Match(value) {
-				fmt.Println(globValue)
-				return nil
-			}
+		if matched {
+			fmt.Println(match)
+			return nil
 		}
 
 		return fmt.Errorf("No matching entries")
@@ -24,7 +24,10 @@ var globCommand = cli.Command{
 			return fmt.Errorf("no value given")
 		}
 
-		g := glob.MustCompile(pattern)
+		g, err := glob.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
 		if !g.Match(value) {
 			return fmt.Errorf("not a match")
 		}
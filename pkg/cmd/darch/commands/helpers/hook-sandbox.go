@@ -0,0 +1,27 @@
+package helpers
+
+import (
+	"fmt"
+
+	"github.com/godarch/darch/pkg/hooks"
+	"github.com/urfave/cli"
+)
+
+var hookSandboxCommand = cli.Command{
+	Name:            hooks.SandboxHelperCommand,
+	Usage:           "run a hook in the sandbox, only used internally",
+	ArgsUsage:       "<destination-directory> <command> [args...]",
+	SkipFlagParsing: true,
+	Action: func(clicontext *cli.Context) error {
+		var (
+			destinationDirectory = clicontext.Args().First()
+			args                 = clicontext.Args().Tail()
+		)
+
+		if len(destinationDirectory) == 0 {
+			return fmt.Errorf("no destination directory given")
+		}
+
+		return hooks.EnterSandbox(destinationDirectory, args)
+	},
+}
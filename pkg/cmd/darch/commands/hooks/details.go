@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/godarch/darch/pkg/hooks"
+	"github.com/godarch/darch/pkg/reference"
 	"github.com/godarch/darch/pkg/staging"
 	"github.com/urfave/cli"
 )
@@ -16,11 +17,16 @@ var detailsCommand = cli.Command{
 		cli.BoolFlag{
 			Name: "include-matched-images",
 		},
+		cli.StringFlag{
+			Name:  "image",
+			Usage: "show the params of the hook for this image",
+		},
 	},
 	Action: func(clicontext *cli.Context) error {
 		var (
 			hookName             = clicontext.Args().First()
 			includeMatchedImages = clicontext.Bool("include-matched-images")
+			imageName            = clicontext.String("image")
 		)
 
 		hook, err := hooks.GetHook(hookName)
@@ -29,8 +35,31 @@ var detailsCommand = cli.Command{
 		}
 
 		fmt.Printf("name: %s\n", hook.Name)
-		fmt.Printf("path: %s\n", hook.Path)
+		if hook.Builtin != nil {
+			fmt.Printf("path: (built-in)\n")
+		} else {
+			fmt.Printf("path: %s\n", hook.Path)
+		}
 		fmt.Printf("executionOrder: %d\n", hook.ExecutionOrder)
+		fmt.Printf("resolvedOrder: %d\n", hook.ResolvedOrder)
+		fmt.Printf("after:\n")
+		for _, after := range hook.After {
+			fmt.Printf("\t%s\n", after)
+		}
+		fmt.Printf("before:\n")
+		for _, before := range hook.Before {
+			fmt.Printf("\t%s\n", before)
+		}
+		fmt.Printf("requires:\n")
+		for _, requirement := range append(hook.RequiresCommands, hook.RequiresFiles...) {
+			fmt.Printf("\t%s\n", requirement)
+		}
+		if unsatisfied := hooks.GetUnsatisfiedRequirements(hook); len(unsatisfied) > 0 {
+			fmt.Printf("missing requirements:\n")
+			for _, requirement := range unsatisfied {
+				fmt.Printf("\t%s\n", requirement)
+			}
+		}
 		fmt.Printf("include images:\n")
 		for _, includeImage := range hook.IncludeImages {
 			fmt.Printf("\t%s\n", includeImage)
@@ -40,6 +69,32 @@ var detailsCommand = cli.Command{
 			fmt.Printf("\t%s\n", excludeImage)
 		}
 
+		fmt.Printf("params:\n")
+		for _, param := range hooks.GetParamsEnvironment(hook.Params) {
+			fmt.Printf("\t%s\n", param)
+		}
+		fmt.Printf("image params:\n")
+		for _, imageParams := range hook.ImageParams {
+			fmt.Printf("\t%s\n", imageParams.Image)
+			for _, param := range hooks.GetParamsEnvironment(imageParams.Params) {
+				fmt.Printf("\t\t%s\n", param)
+			}
+		}
+		if len(imageName) > 0 {
+			imageRef, err := reference.ParseImage(imageName)
+			if err != nil {
+				return err
+			}
+			params, err := hooks.GetHookParams(hook, imageRef)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("params for %s:\n", imageRef.FullName())
+			for _, param := range hooks.GetParamsEnvironment(params) {
+				fmt.Printf("\t%s\n", param)
+			}
+		}
+
 		if includeMatchedImages {
 			stagingSession, err := staging.NewSession()
 			if err != nil {
@@ -52,8 +107,12 @@ var detailsCommand = cli.Command{
 				return err
 			}
 			for _, image := range images {
-				if hooks.AppliesToImage(hook, image.Ref) {
-					fmt.Printf("\t%s\n", image.Ref.FullName())
+				applies, err := hooks.AppliesToImage(hook, image.Ref)
+				if err != nil {
+					return err
+				}
+				if applies {
+					fmt.Printf("\t%s\n", image.DisplayName())
 				}
 			}
 		}
@@ -0,0 +1,63 @@
+package hooks
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/godarch/darch/pkg/cmd/darch/commands"
+	"github.com/godarch/darch/pkg/staging"
+	"github.com/urfave/cli"
+)
+
+var diffCommand = cli.Command{
+	Name:      "diff",
+	Usage:     "show what changed in the output of the hooks for an image, since they were last installed",
+	ArgsUsage: "<image[:tag][@prev]>",
+	Action: func(clicontext *cli.Context) error {
+		var (
+			imageName = clicontext.Args().First()
+		)
+
+		err := commands.CheckForRoot()
+		if err != nil {
+			return err
+		}
+
+		imageRef, previous, err := staging.ParseStagedName(imageName)
+		if err != nil {
+			return err
+		}
+
+		stagingSession, err := staging.NewSession()
+		if err != nil {
+			return err
+		}
+
+		stagedImage, err := stagingSession.GetStaged(imageRef, previous)
+		if err != nil {
+			return err
+		}
+
+		changes, err := stagingSession.GetHookChanges(stagedImage)
+		if err != nil {
+			return err
+		}
+
+		if len(changes) == 0 {
+			fmt.Println("no changes")
+			return nil
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 1, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "HOOK\tCHANGE\tPATH\t")
+		for _, change := range changes {
+			p := change.Path
+			if change.Injected {
+				p = fmt.Sprintf("%s (rootfs)", p)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t\n", change.Hook, change.Change, p)
+		}
+		return tw.Flush()
+	},
+}
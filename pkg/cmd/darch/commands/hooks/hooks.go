@@ -13,6 +13,10 @@ var (
 			listCommand,
 			helpCommand,
 			detailsCommand,
+			diffCommand,
+			newCommand,
+			validateCommand,
+			statusCommand,
 		},
 	}
 )
@@ -2,6 +2,9 @@ package hooks
 
 import (
 	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/godarch/darch/pkg/hooks"
 	"github.com/urfave/cli"
@@ -9,18 +12,27 @@ import (
 
 var listCommand = cli.Command{
 	Name:  "list",
-	Usage: "list hooks",
+	Usage: "list hooks, in the order they run",
 	Action: func(clicontext *cli.Context) error {
 
-		hooks, err := hooks.GetHooks()
+		allHooks, err := hooks.GetHooks()
 		if err != nil {
 			return err
 		}
 
-		for _, hook := range hooks {
-			fmt.Println(hook.Name)
+		tw := tabwriter.NewWriter(os.Stdout, 1, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "ORDER\tNAME\tREQUIREMENTS\t")
+		for _, hook := range allHooks {
+			requirements := "ok"
+			if unsatisfied := hooks.GetUnsatisfiedRequirements(hook); len(unsatisfied) > 0 {
+				requirements = "missing " + strings.Join(unsatisfied, ", ")
+			}
+			fmt.Fprintf(tw, "%v\t%v\t%v\t\n",
+				hook.ResolvedOrder,
+				hook.Name,
+				requirements)
 		}
 
-		return nil
+		return tw.Flush()
 	},
 }
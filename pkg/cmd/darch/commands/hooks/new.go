@@ -0,0 +1,34 @@
+package hooks
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/godarch/darch/pkg/cmd/darch/commands"
+	"github.com/godarch/darch/pkg/hooks"
+	"github.com/urfave/cli"
+)
+
+var newCommand = cli.Command{
+	Name:      "new",
+	Usage:     "create a hook from a template, and configure it for every image",
+	ArgsUsage: "<hook>",
+	Action: func(clicontext *cli.Context) error {
+		var (
+			hookName = clicontext.Args().First()
+		)
+
+		err := commands.CheckForRoot()
+		if err != nil {
+			return err
+		}
+
+		err = hooks.NewHook(hookName)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("created %s\n", path.Join(hooks.DefaultHooksPath, hookName, "hook"))
+		return nil
+	},
+}
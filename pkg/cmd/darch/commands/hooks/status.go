@@ -0,0 +1,112 @@
+package hooks
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/godarch/darch/pkg/cmd/darch/commands"
+	"github.com/godarch/darch/pkg/staging"
+	"github.com/urfave/cli"
+)
+
+var statusCommand = cli.Command{
+	Name:      "status",
+	Usage:     "show when hooks were last ran for the staged images, and if they need to be ran again",
+	ArgsUsage: "[image[:tag][@prev]]",
+	Action: func(clicontext *cli.Context) error {
+		var (
+			imageName = clicontext.Args().First()
+		)
+
+		err := commands.CheckForRoot()
+		if err != nil {
+			return err
+		}
+
+		stagingSession, err := staging.NewSession()
+		if err != nil {
+			return err
+		}
+
+		if len(imageName) > 0 {
+			imageRef, previous, err := staging.ParseStagedName(imageName)
+			if err != nil {
+				return err
+			}
+			stagedImage, err := stagingSession.GetStaged(imageRef, previous)
+			if err != nil {
+				return err
+			}
+			return printImageHooksStatus(stagingSession, stagedImage)
+		}
+
+		stagedImages, err := stagingSession.GetAllStaged()
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 1, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "IMAGE\tLAST RUN\tRESULT\tSTALE\t")
+		for _, stagedImage := range stagedImages {
+			status, err := stagingSession.GetHooksStatus(stagedImage)
+			if err != nil {
+				return err
+			}
+			lastRun, result := "never", "-"
+			if status.LastRun != nil {
+				lastRun = status.LastRun.StartedAt.Format(time.RFC3339)
+				result = getRunResult(*status.LastRun)
+			}
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t\n",
+				stagedImage.DisplayName(),
+				lastRun,
+				result,
+				status.Stale)
+		}
+
+		return tw.Flush()
+	},
+}
+
+func printImageHooksStatus(stagingSession *staging.Session, stagedImage staging.StagedImageNamed) error {
+	status, err := stagingSession.GetHooksStatus(stagedImage)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("image: %s\n", stagedImage.DisplayName())
+	fmt.Printf("stale: %t\n", status.Stale)
+	if status.LastRun == nil {
+		fmt.Printf("last run: never\n")
+		return nil
+	}
+
+	fmt.Printf("last run: %s\n", status.LastRun.StartedAt.Format(time.RFC3339))
+	fmt.Printf("result: %s\n", getRunResult(*status.LastRun))
+	fmt.Printf("duration: %v\n", status.LastRun.Duration.Round(time.Millisecond))
+	fmt.Printf("output: %s\n", status.LastRun.OutputPath)
+	fmt.Printf("hooks:\n")
+
+	tw := tabwriter.NewWriter(os.Stdout, 1, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tHOOK\tEXIT STATUS\tDURATION\tERROR\t")
+	for _, entry := range status.LastRun.Hooks {
+		fmt.Fprintf(tw, "\t%v\t%v\t%v\t%v\t\n",
+			entry.Hook,
+			entry.ExitStatus,
+			entry.Duration.Round(time.Millisecond),
+			entry.Error)
+	}
+	return tw.Flush()
+}
+
+func getRunResult(run staging.HookRun) string {
+	if !run.Succeeded {
+		return "failed"
+	}
+	if !run.Installed {
+		return "not installed"
+	}
+	return "succeeded"
+}
@@ -0,0 +1,30 @@
+package hooks
+
+import (
+	"fmt"
+
+	"github.com/godarch/darch/pkg/hooks"
+	"github.com/urfave/cli"
+)
+
+var validateCommand = cli.Command{
+	Name:  "validate",
+	Usage: "check the hooks and their configuration for problems",
+	Action: func(clicontext *cli.Context) error {
+		problems, err := hooks.ValidateHooks()
+		if err != nil {
+			return err
+		}
+
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+
+		if len(problems) > 0 {
+			return fmt.Errorf("found %d problem(s)", len(problems))
+		}
+
+		fmt.Println("no problems found")
+		return nil
+	},
+}
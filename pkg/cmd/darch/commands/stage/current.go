@@ -12,7 +12,17 @@ import (
 var currentCommand = cli.Command{
 	Name:  "current",
 	Usage: "prints the current booted image",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "only print the name of the booted image",
+		},
+	},
 	Action: func(clicontext *cli.Context) error {
+		var (
+			quiet = clicontext.Bool("quiet")
+		)
+
 		err := commands.CheckForRoot()
 		if err != nil {
 			return err
@@ -33,7 +43,17 @@ var currentCommand = cli.Command{
 			return err
 		}
 
-		fmt.Println(current.Ref.FullName())
+		if quiet {
+			fmt.Println(current.DisplayName())
+			return nil
+		}
+
+		metadata, err := session.GetMetadata(current)
+		if err != nil {
+			return err
+		}
+
+		printStageMetadata(current, metadata)
 
 		return nil
 	},
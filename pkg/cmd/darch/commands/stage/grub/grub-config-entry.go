@@ -5,6 +5,7 @@ import (
 	"github.com/godarch/darch/pkg/block"
 	"github.com/godarch/darch/pkg/cmd/darch/commands"
 	"github.com/godarch/darch/pkg/grub"
+	"github.com/godarch/darch/pkg/staging"
 	"github.com/urfave/cli"
 	"io"
 	"os"
@@ -16,7 +17,11 @@ var grubConfigEntryCommand = cli.Command{
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name:  "cryptodisk,c",
-			Usage: "enable cryptodisk feature (for encrypted /boot)",
+			Usage: "always enable cryptodisk feature (detected automatically for encrypted devices)",
+		},
+		cli.BoolFlag{
+			Name:  "grub-mkconfig-lib",
+			Usage: "use prepare_grub_to_access_device from grub-mkconfig_lib instead of generating the commands natively",
 		},
 	},
 	Action: func(clicontext *cli.Context) error {
@@ -27,20 +32,33 @@ var grubConfigEntryCommand = cli.Command{
 
 		configPath := "/etc/darch/"
 
-		device, err := block.GetBlockDeviceForPath(configPath)
+		device, err := block.GetDeviceForPath(configPath)
 		if err != nil {
 			return err
 		}
 
-		relativePathToDevice, err := block.GetPathRelativeToBlockDevice(configPath)
+		relativePathToDevice := grub.PathOnDevice(device, device.RelativePath)
+
+		config, err := staging.LoadConfiguration()
 		if err != nil {
 			return err
 		}
 
 		// Write the required grub code to access the device that our darch grub.cfg exists.
-		err = grub.PrepareAccessToDevice(device, os.Stdout, clicontext.Bool("cryptodisk"))
-		if err != nil {
-			return err
+		if (config.GrubMkconfigLib || clicontext.Bool("grub-mkconfig-lib")) && len(device.Path) > 0 {
+			encrypted, err := block.IsEncrypted(device.Path)
+			if err != nil {
+				return err
+			}
+			err = grub.PrepareAccessToDevice(device.Path, os.Stdout, encrypted || clicontext.Bool("cryptodisk"))
+			if err != nil {
+				return err
+			}
+		} else {
+			err = grub.PrepareAccessToBlockDevice(device, os.Stdout, clicontext.Bool("cryptodisk"))
+			if err != nil {
+				return err
+			}
 		}
 
 		// Write the code that actually sources our grub.cfg file.
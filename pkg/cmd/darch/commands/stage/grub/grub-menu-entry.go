@@ -3,7 +3,6 @@ package grub
 import (
 	"fmt"
 	"github.com/godarch/darch/pkg/cmd/darch/commands"
-	"github.com/godarch/darch/pkg/reference"
 	"github.com/godarch/darch/pkg/staging"
 	"github.com/urfave/cli"
 	"os"
@@ -12,7 +11,7 @@ import (
 var grubMenuEntryCommand = cli.Command{
 	Name:        "menu-entry",
 	Description: "output a menu entry for a staged item",
-	ArgsUsage:   "<image[:tag]>",
+	ArgsUsage:   "<image[:tag][@prev]>",
 	Action: func(clicontext *cli.Context) error {
 		var (
 			imageName = clicontext.Args().First()
@@ -23,7 +22,7 @@ var grubMenuEntryCommand = cli.Command{
 			return err
 		}
 
-		imageRef, err := reference.ParseImage(imageName)
+		imageRef, previous, err := staging.ParseStagedName(imageName)
 		if err != nil {
 			return err
 		}
@@ -39,7 +38,7 @@ var grubMenuEntryCommand = cli.Command{
 		}
 
 		for _, stagedImage := range stagedImages {
-			if stagedImage.Ref.FullName() == imageRef.FullName() {
+			if stagedImage.Ref.FullName() == imageRef.FullName() && stagedImage.Previous == previous {
 				return session.PrintGrubMenuEntry(stagedImage, os.Stdout)
 			}
 		}
@@ -0,0 +1,61 @@
+package kernelparams
+
+import (
+	"fmt"
+
+	"github.com/godarch/darch/pkg/cmd/darch/commands"
+	"github.com/godarch/darch/pkg/staging"
+	"github.com/urfave/cli"
+)
+
+var getCommand = cli.Command{
+	Name:      "get",
+	Usage:     "print the kernel params set for a staged image",
+	ArgsUsage: "<image[:tag][@prev]>",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "effective",
+			Usage: "print every param the image boots with, including the ones from the image and " + staging.DefaultKernelParamsLocation,
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			imageName = clicontext.Args().First()
+			effective = clicontext.Bool("effective")
+		)
+
+		err := commands.CheckForRoot()
+		if err != nil {
+			return err
+		}
+
+		imageRef, previous, err := staging.ParseStagedName(imageName)
+		if err != nil {
+			return err
+		}
+
+		stagingSession, err := staging.NewSession()
+		if err != nil {
+			return err
+		}
+
+		stagedImage, err := stagingSession.GetStaged(imageRef, previous)
+		if err != nil {
+			return err
+		}
+
+		params := ""
+		if effective {
+			params, err = stagingSession.GetEffectiveKernelParams(stagedImage)
+		} else {
+			params, err = stagingSession.GetKernelParams(stagedImage)
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(params)
+
+		return nil
+	},
+}
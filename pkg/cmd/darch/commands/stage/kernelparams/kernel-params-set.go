@@ -0,0 +1,49 @@
+package kernelparams
+
+import (
+	"strings"
+
+	"github.com/godarch/darch/pkg/cmd/darch/commands"
+	"github.com/godarch/darch/pkg/staging"
+	"github.com/urfave/cli"
+)
+
+var setCommand = cli.Command{
+	Name:        "set",
+	Usage:       "set the kernel params for a staged image",
+	ArgsUsage:   "<image[:tag][@prev]> [params...]",
+	Description: "Replaces the params set for the stage. Giving no params removes them.",
+	Action: func(clicontext *cli.Context) error {
+		var (
+			imageName = clicontext.Args().First()
+			params    = strings.Join(clicontext.Args().Tail(), " ")
+		)
+
+		err := commands.CheckForRoot()
+		if err != nil {
+			return err
+		}
+
+		imageRef, previous, err := staging.ParseStagedName(imageName)
+		if err != nil {
+			return err
+		}
+
+		stagingSession, err := staging.NewSession()
+		if err != nil {
+			return err
+		}
+
+		stagedImage, err := stagingSession.GetStaged(imageRef, previous)
+		if err != nil {
+			return err
+		}
+
+		err = stagingSession.SetKernelParams(stagedImage, params)
+		if err != nil {
+			return err
+		}
+
+		return stagingSession.SyncBootloader()
+	},
+}
@@ -0,0 +1,22 @@
+package kernelparams
+
+import (
+	"fmt"
+
+	"github.com/godarch/darch/pkg/staging"
+	"github.com/urfave/cli"
+)
+
+var (
+	// Command The commands for managing the kernel params of staged images.
+	Command = cli.Command{
+		Name:  "kernel-params",
+		Usage: "manage the kernel params of staged images",
+		Description: fmt.Sprintf("Params set here apply to a single stage. "+
+			"Params for every stage of an image can be configured in %s.", staging.DefaultKernelParamsLocation),
+		Subcommands: cli.Commands{
+			getCommand,
+			setCommand,
+		},
+	}
+)
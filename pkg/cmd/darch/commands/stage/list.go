@@ -2,6 +2,9 @@ package stage
 
 import (
 	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/godarch/darch/pkg/cmd/darch/commands"
 	"github.com/godarch/darch/pkg/staging"
@@ -11,7 +14,17 @@ import (
 var listCommand = cli.Command{
 	Name:  "list",
 	Usage: "list all staged images",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "only print the names of the staged images",
+		},
+	},
 	Action: func(clicontext *cli.Context) error {
+		var (
+			quiet = clicontext.Bool("quiet")
+		)
+
 		err := commands.CheckForRoot()
 		if err != nil {
 			return err
@@ -27,9 +40,30 @@ var listCommand = cli.Command{
 			return err
 		}
 
+		if quiet {
+			for _, stagedImage := range stagedImages {
+				fmt.Println(stagedImage.DisplayName())
+			}
+			return nil
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 1, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "IMAGE\tUPLOADED\tBY\tHOOKS RAN\tSTATE\tNOTES\t")
 		for _, stagedImage := range stagedImages {
-			fmt.Println(stagedImage.Ref.FullName())
+			metadata, err := session.GetMetadata(stagedImage)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t\n",
+				stagedImage.DisplayName(),
+				formatMetadataTime(metadata.UploadedAt),
+				formatMetadataValue(metadata.UploadedBy),
+				formatMetadataTime(metadata.HooksRanAt),
+				formatMetadataValue(strings.Join(getStageStates(metadata), ",")),
+				// Only the first line of the notes fits in the table.
+				formatMetadataValue(strings.SplitN(metadata.Notes, "\n", 2)[0]))
 		}
-		return nil
+
+		return tw.Flush()
 	},
 }
@@ -0,0 +1,59 @@
+package stage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/godarch/darch/pkg/staging"
+)
+
+// printStageMetadata Prints everything known about a staged image.
+func printStageMetadata(stagedImage staging.StagedImageNamed, metadata staging.StageMetadata) {
+	fmt.Printf("image: %s\n", stagedImage.DisplayName())
+	fmt.Printf("id: %s\n", stagedImage.ID)
+	fmt.Printf("source digest: %s\n", formatMetadataValue(metadata.SourceDigest))
+	fmt.Printf("uploaded: %s\n", formatMetadataTime(metadata.UploadedAt))
+	fmt.Printf("uploaded by: %s\n", formatMetadataValue(metadata.UploadedBy))
+	fmt.Printf("uploaded with: %s\n", formatMetadataValue(metadata.UploadedWith))
+	fmt.Printf("hooks ran: %s\n", formatMetadataTime(metadata.HooksRanAt))
+	fmt.Printf("booted: %t\n", metadata.Booted)
+	fmt.Printf("default: %t\n", metadata.Default)
+	fmt.Printf("pinned: %t\n", metadata.Pinned)
+	if len(metadata.Notes) > 0 {
+		fmt.Printf("notes:\n")
+		for _, line := range strings.Split(metadata.Notes, "\n") {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+}
+
+// getStageStates Get the flags of a staged image, as shown in "stage list".
+func getStageStates(metadata staging.StageMetadata) []string {
+	result := []string{}
+	if metadata.Booted {
+		result = append(result, "booted")
+	}
+	if metadata.Default {
+		result = append(result, "default")
+	}
+	if metadata.Pinned {
+		result = append(result, "pinned")
+	}
+	return result
+}
+
+// formatMetadataTime Stages uploaded before metadata was stored don't have it.
+func formatMetadataTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func formatMetadataValue(value string) string {
+	if len(value) == 0 {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,67 @@
+package stage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/godarch/darch/pkg/cmd/darch/commands"
+	"github.com/godarch/darch/pkg/staging"
+	"github.com/urfave/cli"
+)
+
+var notesCommand = cli.Command{
+	Name:        "notes",
+	Usage:       "show or set the notes of a staged image",
+	ArgsUsage:   "<image[:tag][@prev]> [notes...]",
+	Description: "Without notes, the current notes are printed. Use --clear to remove them.",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "clear",
+			Usage: "remove the notes",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			imageName = clicontext.Args().First()
+			notes     = strings.Join(clicontext.Args().Tail(), " ")
+			clear     = clicontext.Bool("clear")
+		)
+
+		err := commands.CheckForRoot()
+		if err != nil {
+			return err
+		}
+
+		if clear && len(notes) > 0 {
+			return fmt.Errorf("can't set notes and --clear at the same time")
+		}
+
+		imageRef, previous, err := staging.ParseStagedName(imageName)
+		if err != nil {
+			return err
+		}
+
+		stagingSession, err := staging.NewSession()
+		if err != nil {
+			return err
+		}
+
+		stagedImage, err := stagingSession.GetStaged(imageRef, previous)
+		if err != nil {
+			return err
+		}
+
+		if len(notes) == 0 && !clear {
+			metadata, err := stagingSession.GetMetadata(stagedImage)
+			if err != nil {
+				return err
+			}
+			if len(metadata.Notes) > 0 {
+				fmt.Println(metadata.Notes)
+			}
+			return nil
+		}
+
+		return stagingSession.SetNotes(stagedImage, notes)
+	},
+}
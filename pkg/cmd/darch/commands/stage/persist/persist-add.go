@@ -0,0 +1,37 @@
+package persist
+
+import (
+	"fmt"
+
+	"github.com/godarch/darch/pkg/cmd/darch/commands"
+	"github.com/godarch/darch/pkg/staging"
+	"github.com/urfave/cli"
+)
+
+var addCommand = cli.Command{
+	Name:      "add",
+	Usage:     "persist a path for every image matching a glob",
+	ArgsUsage: "<image-glob> <path>",
+	Action: func(clicontext *cli.Context) error {
+		var (
+			imageGlob = clicontext.Args().First()
+			p         = clicontext.Args().Get(1)
+		)
+
+		if len(imageGlob) == 0 || len(p) == 0 {
+			return fmt.Errorf("an image glob and a path are required")
+		}
+
+		err := commands.CheckForRoot()
+		if err != nil {
+			return err
+		}
+
+		err = staging.AddPersistedPath(imageGlob, p)
+		if err != nil {
+			return err
+		}
+
+		return syncStage()
+	},
+}
@@ -0,0 +1,47 @@
+package persist
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/godarch/darch/pkg/reference"
+	"github.com/godarch/darch/pkg/staging"
+	"github.com/urfave/cli"
+)
+
+var listCommand = cli.Command{
+	Name:      "list",
+	Usage:     "list the persisted paths, or the ones persisted for an image",
+	ArgsUsage: "[image[:tag]]",
+	Action: func(clicontext *cli.Context) error {
+		var (
+			imageName = clicontext.Args().First()
+		)
+
+		entries, err := staging.LoadPersistEntries()
+		if err != nil {
+			return err
+		}
+
+		if len(imageName) > 0 {
+			imageRef, err := reference.ParseImage(imageName)
+			if err != nil {
+				return err
+			}
+			for _, p := range staging.GetPersistedPaths(entries, imageRef) {
+				fmt.Println(p)
+			}
+			return nil
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 1, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "IMAGES\tPATH\t")
+		for _, entry := range entries {
+			for _, p := range entry.Paths {
+				fmt.Fprintf(tw, "%v\t%v\t\n", entry.Glob, p)
+			}
+		}
+		return tw.Flush()
+	},
+}
@@ -0,0 +1,41 @@
+package persist
+
+import (
+	"fmt"
+
+	"github.com/godarch/darch/pkg/cmd/darch/commands"
+	"github.com/godarch/darch/pkg/staging"
+	"github.com/urfave/cli"
+)
+
+var removeCommand = cli.Command{
+	Name:        "remove",
+	Usage:       "stop persisting a path for a glob",
+	ArgsUsage:   "<image-glob> <path>",
+	Description: "The data that was persisted is left in place.",
+	Action: func(clicontext *cli.Context) error {
+		var (
+			imageGlob = clicontext.Args().First()
+			p         = clicontext.Args().Get(1)
+		)
+
+		if len(imageGlob) == 0 || len(p) == 0 {
+			return fmt.Errorf("an image glob and a path are required")
+		}
+
+		err := commands.CheckForRoot()
+		if err != nil {
+			return err
+		}
+
+		removed, err := staging.RemovePersistedPath(imageGlob, p)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return fmt.Errorf("%s isn't persisted for %s", p, imageGlob)
+		}
+
+		return syncStage()
+	},
+}
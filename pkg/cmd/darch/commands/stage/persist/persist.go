@@ -0,0 +1,39 @@
+package persist
+
+import (
+	"fmt"
+
+	"github.com/godarch/darch/pkg/staging"
+	"github.com/urfave/cli"
+)
+
+var (
+	// Command The commands for managing the paths persisted across stages.
+	Command = cli.Command{
+		Name:  "persist",
+		Usage: "manage the paths persisted across stages",
+		Description: fmt.Sprintf("Persisted paths are configured in %s, and stored in %s by image name.",
+			staging.DefaultPersistLocation,
+			staging.DefaultPersistDirectory),
+		Subcommands: cli.Commands{
+			listCommand,
+			addCommand,
+			removeCommand,
+		},
+	}
+)
+
+// syncStage Updates the paths to persist in every stage, and the bootloader.
+func syncStage() error {
+	stagingSession, err := staging.NewSession()
+	if err != nil {
+		return err
+	}
+
+	err = stagingSession.SyncPersistedPaths()
+	if err != nil {
+		return err
+	}
+
+	return stagingSession.SyncBootloader()
+}
@@ -0,0 +1,53 @@
+package stage
+
+import (
+	"github.com/godarch/darch/pkg/cmd/darch/commands"
+	"github.com/godarch/darch/pkg/staging"
+	"github.com/urfave/cli"
+)
+
+var pinCommand = cli.Command{
+	Name:        "pin",
+	Usage:       "protect a staged image from being removed or replaced",
+	ArgsUsage:   "<image[:tag][@prev]>",
+	Description: "Pinned images are skipped by clean and prune, and can't be removed, or replaced by a forced upload, pull or tag without --unpin.",
+	Action: func(clicontext *cli.Context) error {
+		return setPinned(clicontext.Args().First(), true)
+	},
+}
+
+var unpinCommand = cli.Command{
+	Name:      "unpin",
+	Usage:     "allow a pinned image to be removed or replaced again",
+	ArgsUsage: "<image[:tag][@prev]>",
+	Action: func(clicontext *cli.Context) error {
+		return setPinned(clicontext.Args().First(), false)
+	},
+}
+
+func setPinned(imageName string, pinned bool) error {
+	err := commands.CheckForRoot()
+	if err != nil {
+		return err
+	}
+
+	imageRef, previous, err := staging.ParseStagedName(imageName)
+	if err != nil {
+		return err
+	}
+
+	stagingSession, err := staging.NewSession()
+	if err != nil {
+		return err
+	}
+
+	stagedImage, err := stagingSession.GetStaged(imageRef, previous)
+	if err != nil {
+		return err
+	}
+
+	if pinned {
+		return stagingSession.Pin(stagedImage)
+	}
+	return stagingSession.Unpin(stagedImage)
+}
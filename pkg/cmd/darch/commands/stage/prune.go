@@ -0,0 +1,87 @@
+package stage
+
+import (
+	"fmt"
+
+	"github.com/godarch/darch/pkg/cmd/darch/commands"
+	"github.com/godarch/darch/pkg/staging"
+	"github.com/urfave/cli"
+)
+
+var pruneCommand = cli.Command{
+	Name:  "prune",
+	Usage: "remove old staged images, using the retention policy",
+	Description: fmt.Sprintf("Images are removed when they violate every given rule. "+
+		"The currently booted image and the default boot image are never removed. "+
+		"Rules that aren't given are read from %s.", staging.DefaultStageConfigLocation),
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "keep-last",
+			Usage: "keep the newest N images",
+		},
+		cli.BoolFlag{
+			Name:  "per-name",
+			Usage: "apply --keep-last to each image name, instead of the entire stage",
+		},
+		cli.StringFlag{
+			Name:  "older-than",
+			Usage: "only remove images older than the given age (30d, 2w, 12h)",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "print the images that would be removed, without removing them",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		var (
+			dryRun = clicontext.Bool("dry-run")
+		)
+
+		err := commands.CheckForRoot()
+		if err != nil {
+			return err
+		}
+
+		stagingSession, err := staging.NewSession()
+		if err != nil {
+			return err
+		}
+
+		policy := stagingSession.Configuration().Retention.RetentionPolicy
+		if clicontext.IsSet("keep-last") {
+			policy.KeepLast = clicontext.Int("keep-last")
+		}
+		if clicontext.IsSet("per-name") {
+			policy.PerName = clicontext.Bool("per-name")
+		}
+		if clicontext.IsSet("older-than") {
+			policy.OlderThan, err = staging.ParseAge(clicontext.String("older-than"))
+			if err != nil {
+				return err
+			}
+		}
+
+		if policy.IsEmpty() {
+			return fmt.Errorf("no retention policy given, use --keep-last and/or --older-than")
+		}
+
+		removed, err := stagingSession.Prune(policy, dryRun)
+		if err != nil {
+			return err
+		}
+
+		for _, image := range removed {
+			if dryRun {
+				fmt.Printf("would remove %s\n", image.DisplayName())
+			} else {
+				fmt.Printf("removed %s\n", image.DisplayName())
+			}
+		}
+
+		if dryRun || len(removed) == 0 {
+			return nil
+		}
+
+		return stagingSession.SyncBootloader()
+	},
+}
@@ -0,0 +1,119 @@
+package stage
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/godarch/darch/pkg/cmd/darch/commands"
+	"github.com/godarch/darch/pkg/reference"
+	"github.com/godarch/darch/pkg/repository"
+	"github.com/godarch/darch/pkg/staging"
+	"github.com/urfave/cli"
+)
+
+var pullCommand = cli.Command{
+	Name:        "pull",
+	Usage:       "pull an image from a remote registry directly to the stage",
+	Description: "The image is only kept in containerd while it is being staged, so that it can be garbage collected afterwards.",
+	ArgsUsage:   "[flags] <image>",
+	Flags: append([]cli.Flag{
+		cli.BoolFlag{
+			Name:  "force",
+			Usage: "overwrite existing image with the given name, keeping it as <image>@prev",
+		},
+		extractFlag,
+		unpinFlag,
+	}, commands.RegistryFlags...),
+	Action: func(clicontext *cli.Context) error {
+		var (
+			imageName = clicontext.Args().First()
+			force     = clicontext.Bool("force")
+			unpin     = clicontext.Bool("unpin")
+		)
+
+		err := commands.CheckForRoot()
+		if err != nil {
+			return err
+		}
+
+		extractMode, err := repository.ParseExtractMode(clicontext.String("extract"))
+		if err != nil {
+			return err
+		}
+
+		imageRef, err := reference.ParseImage(imageName)
+		if err != nil {
+			return err
+		}
+
+		resolver, err := commands.GetResolver(clicontext)
+		if err != nil {
+			return err
+		}
+
+		repo, err := repository.NewSession(repository.DefaultContainerdSocketLocation)
+		if err != nil {
+			return err
+		}
+		defer repo.Close()
+
+		stagingSession, err := staging.NewSession()
+		if err != nil {
+			return err
+		}
+
+		// If the user isn't forcing this pull, let's do a quick check to see if it is already staged.
+		if !force {
+			isStaged, err := stagingSession.IsStaged(imageRef)
+			if err != nil {
+				return err
+			}
+			if isStaged {
+				return fmt.Errorf("image already exists on stage, --force to overwrite")
+			}
+		}
+
+		// Fail before extracting anything, if a pinned image would be replaced.
+		err = stagingSession.CheckReplaceable(imageRef, unpin)
+		if err != nil {
+			return err
+		}
+
+		// Everything we pull is held by this lease. Once it is released,
+		// the content can be garbage collected.
+		ctx, done, err := repo.WithLease(context.Background())
+		if err != nil {
+			return err
+		}
+		defer func() {
+			// Like removing the image, a lease that isn't released is only worth a warning.
+			if err := done(ctx); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: couldn't release the lease on %s: %v\n", imageRef.FullName(), err)
+			}
+		}()
+
+		// Don't remove images that the user already had locally.
+		hadImage, err := repo.HasImage(ctx, imageRef)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("pulling %s\n", imageRef.FullName())
+		_, err = repo.Pull(ctx, imageRef, resolver)
+		if err != nil {
+			return err
+		}
+		if !hadImage {
+			defer func() {
+				// The image is staged (or not) either way, so a failed clean up is only worth a warning.
+				if err := repo.RemoveImage(ctx, imageRef.FullName()); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: couldn't remove %s from containerd: %v\n", imageRef.FullName(), err)
+				}
+			}()
+		}
+
+		fmt.Printf("staging %s\n", imageRef.FullName())
+		return stageImage(ctx, repo, stagingSession, imageRef, extractMode, force, unpin)
+	},
+}
@@ -2,7 +2,6 @@ package stage
 
 import (
 	"github.com/godarch/darch/pkg/cmd/darch/commands"
-	"github.com/godarch/darch/pkg/reference"
 	"github.com/godarch/darch/pkg/staging"
 	"github.com/urfave/cli"
 )
@@ -10,7 +9,7 @@ import (
 var removeCommand = cli.Command{
 	Name:      "remove",
 	Usage:     "removes an image from the stage",
-	ArgsUsage: "<image[:tag]>",
+	ArgsUsage: "<image[:tag][@prev]>",
 	Action: func(clicontext *cli.Context) error {
 		var (
 			imageName = clicontext.Args().First()
@@ -21,7 +20,7 @@ var removeCommand = cli.Command{
 			return err
 		}
 
-		imageRef, err := reference.ParseImage(imageName)
+		imageRef, previous, err := staging.ParseStagedName(imageName)
 		if err != nil {
 			return err
 		}
@@ -31,7 +30,11 @@ var removeCommand = cli.Command{
 			return err
 		}
 
-		err = stagingSession.Remove(imageRef)
+		if previous {
+			err = stagingSession.RemovePrevious(imageRef)
+		} else {
+			err = stagingSession.Remove(imageRef)
+		}
 		if err != nil {
 			return err
 		}
@@ -11,9 +11,16 @@ var runHooksCommand = cli.Command{
 	Name:      "run-hooks",
 	Usage:     "run hooks for image(s)",
 	ArgsUsage: "<image[:tag]>",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "jobs,j",
+			Usage: "when running hooks for every image, how many images to run hooks for at once (defaults to hook-jobs in " + staging.DefaultStageConfigLocation + ")",
+		},
+	},
 	Action: func(clicontext *cli.Context) error {
 		var (
 			imageName = clicontext.Args().First()
+			jobs      = clicontext.Int("jobs")
 		)
 
 		err := commands.CheckForRoot()
@@ -34,6 +41,6 @@ var runHooksCommand = cli.Command{
 			return stagingSession.RunHooksForImage(imageRef)
 		}
 
-		return stagingSession.RunAllHooks()
+		return stagingSession.RunAllHooks(jobs)
 	},
 }
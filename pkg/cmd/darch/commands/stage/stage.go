@@ -2,6 +2,8 @@ package stage
 
 import (
 	"github.com/godarch/darch/pkg/cmd/darch/commands/stage/grub"
+	"github.com/godarch/darch/pkg/cmd/darch/commands/stage/kernelparams"
+	"github.com/godarch/darch/pkg/cmd/darch/commands/stage/persist"
 	"github.com/urfave/cli"
 )
 
@@ -13,12 +15,20 @@ var (
 		Subcommands: cli.Commands{
 			listCommand,
 			uploadCommand,
+			pullCommand,
 			removeCommand,
 			tagCommand,
 			runHooksCommand,
 			cleanCommand,
+			pruneCommand,
+			verifyCommand,
 			syncBootloaderCommand,
 			currentCommand,
+			notesCommand,
+			pinCommand,
+			unpinCommand,
+			kernelparams.Command,
+			persist.Command,
 			grub.Command,
 		},
 	}
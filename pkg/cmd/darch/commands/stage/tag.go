@@ -15,12 +15,14 @@ var tagCommand = cli.Command{
 			Name:  "force",
 			Usage: "if overwriting existing tag, delete it",
 		},
+		unpinFlag,
 	},
 	Action: func(clicontext *cli.Context) error {
 		var (
 			sourceImage      = clicontext.Args().First()
 			destinationImage = clicontext.Args().Get(1)
 			force            = clicontext.Bool("force")
+			unpin            = clicontext.Bool("unpin")
 		)
 
 		sourceImageRef, err := reference.ParseImage(sourceImage)
@@ -38,7 +40,7 @@ var tagCommand = cli.Command{
 			return err
 		}
 
-		err = stagingSession.Tag(sourceImageRef, destinationImageRef, force)
+		err = stagingSession.Tag(sourceImageRef, destinationImageRef, force, unpin)
 		if err != nil {
 			return err
 		}
@@ -12,6 +12,18 @@ import (
 	"github.com/urfave/cli"
 )
 
+var (
+	extractFlag = cli.StringFlag{
+		Name:  "extract",
+		Usage: "how to extract the image: auto, native or container (runs /darch-extract)",
+		Value: string(repository.ExtractModeAuto),
+	}
+	unpinFlag = cli.BoolFlag{
+		Name:  "unpin",
+		Usage: "with --force, unpin the image being replaced instead of refusing to replace it",
+	}
+)
+
 var uploadCommand = cli.Command{
 	Name:      "upload",
 	Usage:     "upload local image to stage",
@@ -19,13 +31,16 @@ var uploadCommand = cli.Command{
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name:  "force",
-			Usage: "overwrite existing image with the given name",
+			Usage: "overwrite existing image with the given name, keeping it as <image>@prev",
 		},
+		extractFlag,
+		unpinFlag,
 	},
 	Action: func(clicontext *cli.Context) error {
 		var (
 			imageName = clicontext.Args().First()
 			force     = clicontext.Bool("force")
+			unpin     = clicontext.Bool("unpin")
 		)
 
 		err := commands.CheckForRoot()
@@ -33,6 +48,11 @@ var uploadCommand = cli.Command{
 			return err
 		}
 
+		extractMode, err := repository.ParseExtractMode(clicontext.String("extract"))
+		if err != nil {
+			return err
+		}
+
 		imageRef, err := reference.ParseImage(imageName)
 		if err != nil {
 			return err
@@ -60,29 +80,52 @@ var uploadCommand = cli.Command{
 			}
 		}
 
-		ws, err := workspace.NewWorkspace(staging.DefaultStagingDirectoryTmp)
+		// Fail before extracting anything, if a pinned image would be replaced.
+		err = stagingSession.CheckReplaceable(imageRef, unpin)
 		if err != nil {
 			return err
 		}
-		defer ws.Destroy()
 
-		err = repo.ExtractImage(context.Background(), imageRef, ws.Path)
-		if err != nil {
-			return err
-		}
+		return stageImage(context.Background(), repo, stagingSession, imageRef, extractMode, force, unpin)
+	},
+}
 
-		err = stagingSession.UploadDirectoryWithMove(ws.Path, imageRef, force)
-		if err != nil {
-			return err
-		}
-		ws.MarkDestroyed() // prevent defered Destroy() from working, since we moved the directory to where it should be.
+// stageImage Extracts a local image, uploads it to the stage, runs its hooks,
+// applies the retention policy (if configured) and then updates the bootloader.
+func stageImage(ctx context.Context, repo *repository.Session, stagingSession *staging.Session, imageRef reference.ImageRef, extractMode repository.ExtractMode, force bool, unpin bool) error {
+	ws, err := workspace.NewWorkspace(staging.DefaultStagingDirectoryTmp)
+	if err != nil {
+		return err
+	}
+	defer ws.Destroy()
+
+	err = repo.ExtractImage(ctx, imageRef, ws.Path, extractMode)
+	if err != nil {
+		return err
+	}
 
-		// Run hooks for the new image.
-		err = stagingSession.RunHooksForImage(imageRef)
+	err = stagingSession.UploadDirectoryWithMove(ws.Path, imageRef, force, unpin)
+	if err != nil {
+		return err
+	}
+	ws.MarkDestroyed() // prevent defered Destroy() from working, since we moved the directory to where it should be.
+
+	// Run hooks for the new image.
+	err = stagingSession.RunHooksForImage(imageRef)
+	if err != nil {
+		return err
+	}
+
+	// Apply the retention policy, if the user wants it done on every upload.
+	if config := stagingSession.Configuration(); config.Retention.ApplyOnUpload {
+		removed, err := stagingSession.Prune(config.Retention.RetentionPolicy, false)
 		if err != nil {
 			return err
 		}
+		for _, image := range removed {
+			fmt.Printf("removed %s\n", image.DisplayName())
+		}
+	}
 
-		return stagingSession.SyncBootloader()
-	},
+	return stagingSession.SyncBootloader()
 }
@@ -0,0 +1,67 @@
+package stage
+
+import (
+	"fmt"
+
+	"github.com/godarch/darch/pkg/cmd/darch/commands"
+	"github.com/godarch/darch/pkg/staging"
+	"github.com/urfave/cli"
+)
+
+var verifyCommand = cli.Command{
+	Name:      "verify",
+	Usage:     "verify staged images haven't been modified since they were uploaded",
+	ArgsUsage: "[<image[:tag][@prev]>]",
+	Action: func(clicontext *cli.Context) error {
+		var (
+			imageName = clicontext.Args().First()
+		)
+
+		err := commands.CheckForRoot()
+		if err != nil {
+			return err
+		}
+
+		stagingSession, err := staging.NewSession()
+		if err != nil {
+			return err
+		}
+
+		var stagedImages []staging.StagedImageNamed
+		if len(imageName) > 0 {
+			imageRef, previous, err := staging.ParseStagedName(imageName)
+			if err != nil {
+				return err
+			}
+			stagedImage, err := stagingSession.GetStaged(imageRef, previous)
+			if err != nil {
+				return err
+			}
+			stagedImages = []staging.StagedImageNamed{stagedImage}
+		} else {
+			stagedImages, err = stagingSession.GetAllStaged()
+			if err != nil {
+				return err
+			}
+		}
+
+		failed := 0
+		for _, stagedImage := range stagedImages {
+			err = stagingSession.VerifyImage(stagedImage)
+			if err == staging.ErrNoDigests {
+				fmt.Printf("%s: skipped, %v\n", stagedImage.DisplayName(), err)
+			} else if err != nil {
+				fmt.Printf("%s: failed, %v\n", stagedImage.DisplayName(), err)
+				failed++
+			} else {
+				fmt.Printf("%s: ok\n", stagedImage.DisplayName())
+			}
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d image(s) failed verification", failed)
+		}
+
+		return nil
+	},
+}
@@ -77,7 +77,7 @@ func markdownAction(clicontext *cli.Context) error {
 
 	fmt.Println("## Usage")
 	fmt.Println("")
-	fmt.Printf(clicontext.Command.HelpName)
+	fmt.Print(clicontext.Command.HelpName)
 	if len(clicontext.Command.ArgsUsage) > 0 {
 		fmt.Printf(" %s\n", clicontext.Command.ArgsUsage)
 	} else {
@@ -0,0 +1,165 @@
+package grub
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/godarch/darch/pkg/block"
+	"github.com/godarch/darch/pkg/utils"
+)
+
+// DeviceAccess Everything grub needs to know to find a filesystem.
+type DeviceAccess struct {
+	// PartitionSchemes The partition tables (gpt, dos) the filesystem is stored on.
+	PartitionSchemes []string
+	// CryptoDisks The crypt devices that must be opened. These are only opened if EnableCryptoDisk is set.
+	CryptoDisks      []block.CryptoDisk
+	EnableCryptoDisk bool
+	LVM              bool
+	FSType           string
+	// UUID The filesystem uuid to search for (the pool guid for ZFS).
+	UUID string
+}
+
+// PathOnDevice Get the path grub uses for a path on the given device.
+// The path must be relative to the root of the filesystem (see block.Device.RelativePath),
+// which for btrfs includes the subvolume (/@darch/...). ZFS paths are prefixed with the dataset (/ROOT/default@/...).
+func PathOnDevice(device block.Device, p string) string {
+	if device.FSType != "zfs" {
+		return p
+	}
+	datasetPath := ""
+	if parts := strings.SplitN(device.Dataset, "/", 2); len(parts) == 2 {
+		datasetPath = parts[1]
+	}
+	return fmt.Sprintf("/%s@%s", datasetPath, p)
+}
+
+// GetDeviceAccess Gets what grub needs to know to find the filesystem of the given device.
+func GetDeviceAccess(device block.Device, enableCryptoDisk bool) (DeviceAccess, error) {
+	result := DeviceAccess{
+		EnableCryptoDisk: enableCryptoDisk,
+		FSType:           device.FSType,
+		UUID:             device.UUID,
+	}
+
+	if len(device.Path) == 0 {
+		// ZFS pools have no single block device, so we can't tell how the vdevs are partitioned.
+		result.PartitionSchemes = []string{"gpt", "dos"}
+		return result, nil
+	}
+
+	stack, err := block.GetDeviceStack(device.Path)
+	if err != nil {
+		return result, err
+	}
+
+	result.PartitionSchemes = stack.PartitionSchemes
+	result.CryptoDisks = stack.CryptoDisks
+	result.LVM = stack.LVM
+	if len(stack.CryptoDisks) > 0 {
+		result.EnableCryptoDisk = true
+	}
+
+	return result, nil
+}
+
+// PrepareAccessToBlockDevice Writes out the required info to access a device in grub.
+// This is the native equivalent of PrepareAccessToDevice, which doesn't depend on grub-mkconfig_lib.
+func PrepareAccessToBlockDevice(device block.Device, output io.Writer, enableCryptoDisk bool) error {
+	access, err := GetDeviceAccess(device, enableCryptoDisk)
+	if err != nil {
+		return err
+	}
+	return WriteDeviceAccess(access, output)
+}
+
+// WriteDeviceAccess Writes the grub commands to find a filesystem, and set it as the root.
+// ---------
+// insmod part_gpt
+// insmod cryptodisk
+// insmod luks2
+// insmod ext2
+// cryptomount -u 0c5fdd5b0e4e4b5c8d2ea8d3f14b8fd1
+// search --no-floppy --fs-uuid --set=root a85bf1c9-59a1-4dba-9df9-6fbbfa03466c
+// ---------
+func WriteDeviceAccess(access DeviceAccess, output io.Writer) error {
+	if len(access.UUID) == 0 {
+		return fmt.Errorf("filesystem uuid is required")
+	}
+	if len(access.FSType) == 0 {
+		return fmt.Errorf("filesystem type is required")
+	}
+
+	lines := []string{}
+	for _, scheme := range access.PartitionSchemes {
+		lines = append(lines, fmt.Sprintf("insmod %s", getPartitionModule(scheme)))
+	}
+	if access.EnableCryptoDisk {
+		lines = append(lines, "insmod cryptodisk")
+		modules := []string{}
+		for _, cryptoDisk := range access.CryptoDisks {
+			module := getCryptoDiskModule(cryptoDisk.Type)
+			if len(module) > 0 && !utils.Contains(modules, module) {
+				modules = append(modules, module)
+			}
+		}
+		for _, module := range modules {
+			lines = append(lines, fmt.Sprintf("insmod %s", module))
+		}
+	}
+	if access.LVM {
+		lines = append(lines, "insmod lvm")
+	}
+	lines = append(lines, fmt.Sprintf("insmod %s", getFilesystemModule(access.FSType)))
+	if access.EnableCryptoDisk {
+		for _, cryptoDisk := range access.CryptoDisks {
+			lines = append(lines, fmt.Sprintf("cryptomount -u %s", cryptoDisk.UUID))
+		}
+	}
+	lines = append(lines, fmt.Sprintf("search --no-floppy --fs-uuid --set=root %s", access.UUID))
+
+	for _, line := range lines {
+		_, err := io.WriteString(output, line+"\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getPartitionModule Get the grub module for a partition table, as named by udev/blkid.
+func getPartitionModule(scheme string) string {
+	switch scheme {
+	case "dos":
+		return "part_msdos"
+	default:
+		return "part_" + scheme
+	}
+}
+
+// getCryptoDiskModule Get the grub module for a dm-crypt type.
+func getCryptoDiskModule(cryptType string) string {
+	switch strings.ToUpper(cryptType) {
+	case "LUKS1", "LUKS":
+		return "luks"
+	case "LUKS2":
+		return "luks2"
+	default:
+		return ""
+	}
+}
+
+// getFilesystemModule Get the grub module for a filesystem type, as named by the kernel.
+func getFilesystemModule(fsType string) string {
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		return "ext2"
+	case "vfat", "msdos":
+		return "fat"
+	default:
+		return fsType
+	}
+}
@@ -0,0 +1,59 @@
+package grub
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+var (
+	// DefaultEnvironmentBlockPath The location of the grub environment block (grub-set-default, grub-reboot, etc).
+	DefaultEnvironmentBlockPath = "/boot/grub/grubenv"
+)
+
+// ReadEnvironmentBlock Reads the key/value pairs stored in a grub environment block.
+// A missing environment block is treated as an empty one.
+func ReadEnvironmentBlock(file string) (map[string]string, error) {
+	result := make(map[string]string)
+
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return result, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// The block is padded with '#' characters to a fixed size,
+		// so any comment lines can be ignored.
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		delimiterPosition := strings.Index(line, "=")
+		if delimiterPosition == -1 {
+			continue
+		}
+		result[line[:delimiterPosition]] = line[delimiterPosition+1:]
+	}
+
+	return result, scanner.Err()
+}
+
+// DefaultEntryTitle Get the title of the menu entry grub will boot by default, using
+// the "next_entry" (grub-reboot) or "saved_entry" (grub-set-default) values.
+// Entries nested in submenus are returned without their submenu prefix.
+// Returns an empty string if the default entry can't be determined.
+func DefaultEntryTitle(env map[string]string) string {
+	entry := env["next_entry"]
+	if len(entry) == 0 {
+		entry = env["saved_entry"]
+	}
+	if i := strings.LastIndex(entry, ">"); i != -1 {
+		entry = entry[i+1:]
+	}
+	return entry
+}
@@ -107,6 +107,27 @@ func MenuEntry(name string, contents func(w io.Writer) error, output io.Writer)
 	return nil
 }
 
+// Submenu Generates a submenu, with a callback to write the menu entries within it.
+func Submenu(name string, contents func(w io.Writer) error, output io.Writer) error {
+	if len(name) == 0 {
+		return fmt.Errorf("submenu name required")
+	}
+	_, err := io.WriteString(output, fmt.Sprintf("submenu '%s' {\n", name))
+	if err != nil {
+		return err
+	}
+	tw := indent.NewWriter(output, "  ")
+	err = contents(tw)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(output, "}\n")
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func runCommand(name string, args ...string) ([]string, error) {
 	cmd := exec.Command(name, args...)
 	cmdOut, _ := cmd.StdoutPipe()
@@ -1,9 +1,15 @@
 package grub
 
 import (
+	"bytes"
+	"flag"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"testing"
+
+	"github.com/godarch/darch/pkg/block"
 )
 
 func TestAccess(t *testing.T) {
@@ -39,3 +45,113 @@ func TestMenuEntry(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestPathOnDevice(t *testing.T) {
+	tests := []struct {
+		device   block.Device
+		path     string
+		expected string
+	}{
+		{block.Device{FSType: "ext4"}, "/var/lib/darch/stage", "/var/lib/darch/stage"},
+		{block.Device{FSType: "btrfs", Subvolume: "/@darch"}, "/@darch/stage", "/@darch/stage"},
+		{block.Device{FSType: "zfs", Dataset: "rpool/ROOT/default"}, "/var/lib/darch/stage", "/ROOT/default@/var/lib/darch/stage"},
+		{block.Device{FSType: "zfs", Dataset: "rpool"}, "/stage", "/@/stage"},
+	}
+	for _, test := range tests {
+		result := PathOnDevice(test.device, test.path)
+		if result != test.expected {
+			t.Fatalf("expected %s, got %s", test.expected, result)
+		}
+	}
+}
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestWriteDeviceAccess(t *testing.T) {
+	tests := map[string]DeviceAccess{
+		"access-ext4": {
+			PartitionSchemes: []string{"gpt"},
+			FSType:           "ext4",
+			UUID:             "a85bf1c9-59a1-4dba-9df9-6fbbfa03466c",
+		},
+		"access-btrfs-msdos": {
+			PartitionSchemes: []string{"dos"},
+			FSType:           "btrfs",
+			UUID:             "4f0b0a7e-44d5-4a4e-8f6c-2b1f1f8f3c2d",
+		},
+		"access-lvm-on-luks": {
+			PartitionSchemes: []string{"gpt"},
+			CryptoDisks:      []block.CryptoDisk{{Type: "LUKS1", UUID: "0c5fdd5b0e4e4b5c8d2ea8d3f14b8fd1"}},
+			EnableCryptoDisk: true,
+			LVM:              true,
+			FSType:           "xfs",
+			UUID:             "a85bf1c9-59a1-4dba-9df9-6fbbfa03466c",
+		},
+		"access-luks-disabled": {
+			PartitionSchemes: []string{"gpt"},
+			CryptoDisks:      []block.CryptoDisk{{Type: "LUKS2", UUID: "0c5fdd5b0e4e4b5c8d2ea8d3f14b8fd1"}},
+			FSType:           "ext4",
+			UUID:             "a85bf1c9-59a1-4dba-9df9-6fbbfa03466c",
+		},
+		"access-zfs": {
+			PartitionSchemes: []string{"gpt", "dos"},
+			FSType:           "zfs",
+			UUID:             "0bd8dba3b2a6c6ad",
+		},
+	}
+
+	for name, access := range tests {
+		var b bytes.Buffer
+		err := WriteDeviceAccess(access, &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		goldenFile := path.Join("testdata", name+".golden")
+		if *updateGolden {
+			if err = ioutil.WriteFile(goldenFile, b.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		expected, err := ioutil.ReadFile(goldenFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, b.Bytes()) {
+			t.Fatalf("%s: expected\n%s\ngot\n%s", name, string(expected), b.String())
+		}
+	}
+}
+
+func TestWriteDeviceAccessRequiresUUID(t *testing.T) {
+	var b bytes.Buffer
+	err := WriteDeviceAccess(DeviceAccess{FSType: "ext4"}, &b)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestSubmenu(t *testing.T) {
+	var b bytes.Buffer
+	err := Submenu("Darch - test", func(w io.Writer) error {
+		return MenuEntry("Darch - test:latest", func(w io.Writer) error {
+			return LoadLinux("/vmlinuz", "quiet", "/initrd.img", w)
+		}, w)
+	}, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `submenu 'Darch - test' {
+  menuentry 'Darch - test:latest' {
+    echo 'Loading kernel...'
+    linux /vmlinuz quiet
+    echo 'Loading initial ramdisk...'
+    initrd /initrd.img
+  }
+}
+`
+	if b.String() != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, b.String())
+	}
+}
@@ -0,0 +1,182 @@
+package hooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"github.com/godarch/darch/pkg/reference"
+	"github.com/godarch/darch/pkg/utils"
+)
+
+// BuiltinHook A hook implemented in darch itself, rather than by a hook script in DefaultHooksPath.
+// A built-in hook is off until it has an entry in the hooks configuration, e.g. {"ssh": {}}.
+// A hook directory with the same name takes precedence over a built-in hook.
+type BuiltinHook interface {
+	Name() string
+	Help() string
+	// Install Writes the files to inject into the rootfs at boot to the destination directory.
+	// Returns the files to inject, which are described in the manifest of the hook.
+	Install(context InstallContext) ([]ManifestFile, error)
+}
+
+// InstallContext What a built-in hook is installed for.
+type InstallContext struct {
+	// HooksPath Where the configuration of the hooks lives.
+	HooksPath            string
+	DestinationDirectory string
+	ImageRef             reference.ImageRef
+	// Params The settings of the hook for the image.
+	Params map[string]string
+	Output io.Writer
+}
+
+var builtinHooks = map[string]BuiltinHook{}
+
+// RegisterBuiltinHook Makes a built-in hook available, to be enabled in the hooks configuration.
+func RegisterBuiltinHook(hook BuiltinHook) {
+	builtinHooks[hook.Name()] = hook
+}
+
+func init() {
+	RegisterBuiltinHook(hostnameHook{})
+	RegisterBuiltinHook(machineIDHook{})
+	RegisterBuiltinHook(fstabHook{})
+	RegisterBuiltinHook(sshHook{})
+}
+
+func getBuiltinHookNames() []string {
+	result := make([]string, 0, len(builtinHooks))
+	for name := range builtinHooks {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// InstallBuiltinHook Installs a built-in hook into the destination directory.
+// Next to the files it injects, the hook gets a manifest and a hook script
+// that copies the files into the rootfs at boot.
+func InstallBuiltinHook(hook Hook, context InstallContext) error {
+	if hook.Builtin == nil {
+		return fmt.Errorf("%s isn't a built-in hook", hook.Name)
+	}
+
+	files, err := hook.Builtin.Install(context)
+	if err != nil {
+		return err
+	}
+
+	jsonData, err := json.MarshalIndent(Manifest{Files: files}, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path.Join(context.DestinationDirectory, ManifestFileName), jsonData, 0644)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(context.DestinationDirectory, "hook"), []byte(getBuiltinHookScript(hook.Builtin.Help(), files)), 0755)
+}
+
+// getBuiltinHookScript Get the hook script ran at boot by the initramfs, which copies the files of the manifest into the rootfs.
+func getBuiltinHookScript(help string, files []ManifestFile) string {
+	var b strings.Builder
+	b.WriteString("#!/usr/bin/env sh\nset -e\n\n")
+	b.WriteString("help() {\n")
+	fmt.Fprintf(&b, "    echo %s\n", shellQuote(help))
+	b.WriteString("}\n\n")
+	b.WriteString("install() {\n    true\n}\n\n")
+	b.WriteString("run() {\n    true\n")
+	for _, file := range files {
+		source := fmt.Sprintf("\"$DARCH_HOOK_DIR\"/%s", shellQuote(file.Source))
+		destination := fmt.Sprintf("\"$DARCH_ROOT_FS\"%s", shellQuote(file.Destination))
+		fmt.Fprintf(&b, "    mkdir -p \"$DARCH_ROOT_FS\"%s\n", shellQuote(path.Dir(file.Destination)))
+		fmt.Fprintf(&b, "    rm -f %s\n", destination)
+		fmt.Fprintf(&b, "    cp %s %s\n", source, destination)
+		if len(file.Mode) > 0 {
+			fmt.Fprintf(&b, "    chmod %s %s\n", file.Mode, destination)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// getBuiltinHookParam Get a setting of a built-in hook for the image, from its params in the hooks configuration.
+// Before params existed, settings were configured in a glob config (glob=value per line) in the hooks directory,
+// which is still used if the param isn't set.
+func getBuiltinHookParam(context InstallContext, param string, legacyConfig string) (string, bool, error) {
+	if value, ok := context.Params[param]; ok {
+		return value, true, nil
+	}
+	return getGlobConfigFirstMatch(path.Join(context.HooksPath, legacyConfig), context.ImageRef)
+}
+
+// getGlobConfigFirstMatch Get the value of the first entry in a glob config (glob=value per line) that matches the image.
+// Returns false if the config doesn't exist, or nothing matches.
+func getGlobConfigFirstMatch(configFile string, imageRef reference.ImageRef) (string, bool, error) {
+	if !utils.FileExists(configFile) {
+		return "", false, nil
+	}
+
+	lines, err := utils.GetFileLines(configFile)
+	if err != nil {
+		return "", false, err
+	}
+
+	return GlobConfigFirstMatch(lines, imageRef.FullName())
+}
+
+// GlobConfigFirstMatch Get the value of the first glob=value line that matches the value.
+// Returns false if nothing matches.
+func GlobConfigFirstMatch(lines []string, value string) (string, bool, error) {
+	for _, line := range lines {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		delimiterPosition := strings.LastIndex(line, "=")
+		if delimiterPosition == -1 {
+			return "", false, fmt.Errorf("invalid entry \"%s\"", line)
+		}
+
+		globPattern := line[:delimiterPosition]
+		globValue := line[delimiterPosition+1:]
+
+		if len(globPattern) == 0 {
+			return "", false, fmt.Errorf("invalid entry \"%s\"", line)
+		}
+
+		g, err := glob.Compile(globPattern)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid glob %s: %v", globPattern, err)
+		}
+
+		if g.Match(value) {
+			return globValue, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// copyHostFile Copies a file from the host into the destination directory, returning false if it doesn't exist.
+func copyHostFile(source string, destinationDirectory string, name string) (bool, error) {
+	if _, err := os.Stat(source); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, utils.CopyFile(source, path.Join(destinationDirectory, name))
+}
@@ -0,0 +1,328 @@
+package hooks
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/godarch/darch/pkg/reference"
+	"github.com/godarch/darch/pkg/utils"
+)
+
+func createTestInstallContext(t *testing.T, image string) (InstallContext, func()) {
+	dir, err := ioutil.TempDir("", "darch-builtin-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageRef, err := reference.ParseImage(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context := InstallContext{
+		HooksPath:            path.Join(dir, "hooks"),
+		DestinationDirectory: path.Join(dir, "dest"),
+		ImageRef:             imageRef,
+		Output:               ioutil.Discard,
+	}
+	for _, d := range []string{context.HooksPath, context.DestinationDirectory} {
+		if err = os.MkdirAll(d, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return context, func() { os.RemoveAll(dir) }
+}
+
+func readTestFile(t *testing.T, file string) string {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestHostnameHookTemplate(t *testing.T) {
+	context, cleanup := createTestInstallContext(t, "pauldotknopf/desktop:v2")
+	defer cleanup()
+
+	context.Params = map[string]string{"hostname": "{{.BaseName}}-{{.Tag}}"}
+
+	files, err := hostnameHook{}.Install(context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Destination != "/etc/hostname" {
+		t.Fatalf("unexpected files %v", files)
+	}
+	if hostname := readTestFile(t, path.Join(context.DestinationDirectory, "hostname")); hostname != "desktop-v2\n" {
+		t.Fatalf("unexpected hostname %s", hostname)
+	}
+}
+
+func TestHostnameHookInvalid(t *testing.T) {
+	context, cleanup := createTestInstallContext(t, "desktop:latest")
+	defer cleanup()
+
+	for _, hostnameTemplate := range []string{"{{.Missing}}", "{{.Name", "a b"} {
+		err := ioutil.WriteFile(path.Join(context.HooksPath, "hostname.config"), []byte("*="+hostnameTemplate+"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = (hostnameHook{}).Install(context); err == nil {
+			t.Fatalf("expected %s to be invalid", hostnameTemplate)
+		}
+	}
+}
+
+func TestMachineIDHook(t *testing.T) {
+	context, cleanup := createTestInstallContext(t, "desktop:latest")
+	defer cleanup()
+
+	previous, previousStored := hostMachineIDLocation, DefaultMachineIDLocation
+	hostMachineIDLocation = path.Join(context.HooksPath, "missing")
+	DefaultMachineIDLocation = path.Join(context.HooksPath, "state", "machine-id")
+	defer func() { hostMachineIDLocation, DefaultMachineIDLocation = previous, previousStored }()
+
+	// A machine-id stored in the hooks directory by an older version is moved out of it.
+	legacy := "0123456789abcdef0123456789abcdef\n"
+	if err := ioutil.WriteFile(path.Join(context.HooksPath, "current-machine-id"), []byte(legacy), 0444); err != nil {
+		t.Fatal(err)
+	}
+	_, err := machineIDHook{}.Install(context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated := readTestFile(t, DefaultMachineIDLocation); migrated != legacy || utils.FileExists(path.Join(context.HooksPath, "current-machine-id")) {
+		t.Fatalf("expected the legacy machine-id to be moved, got %s", migrated)
+	}
+
+	os.Remove(DefaultMachineIDLocation)
+	_, err = machineIDHook{}.Install(context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated := readTestFile(t, path.Join(context.DestinationDirectory, "machine-id"))
+	if len(strings.TrimSpace(generated)) != 32 {
+		t.Fatalf("unexpected machine-id %s", generated)
+	}
+
+	// The stored machine-id is reused.
+	os.Remove(path.Join(context.DestinationDirectory, "machine-id"))
+	_, err = machineIDHook{}.Install(context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reused := readTestFile(t, path.Join(context.DestinationDirectory, "machine-id")); reused != generated {
+		t.Fatalf("expected %s, got %s", generated, reused)
+	}
+}
+
+func TestFstabHook(t *testing.T) {
+	context, cleanup := createTestInstallContext(t, "desktop:latest")
+	defer cleanup()
+
+	files, err := fstabHook{}.Install(context)
+	if err != nil || len(files) != 0 {
+		t.Fatalf("expected no fstab without a config, got %v %v", files, err)
+	}
+
+	context.Params = map[string]string{"fstab": "default_fstab"}
+	if _, err = (fstabHook{}).Install(context); err == nil {
+		t.Fatal("expected an error for a missing fstab")
+	}
+
+	if err = ioutil.WriteFile(path.Join(context.HooksPath, "default_fstab"), []byte("tmpfs /tmp tmpfs defaults 0 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, err = fstabHook{}.Install(context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || readTestFile(t, path.Join(context.DestinationDirectory, "fstab")) != "tmpfs /tmp tmpfs defaults 0 0\n" {
+		t.Fatalf("unexpected fstab %v", files)
+	}
+}
+
+func TestSSHHookGeneratesKeys(t *testing.T) {
+	if _, err := exec.LookPath(sshKeygenCommand); err != nil {
+		t.Skipf("%s isn't installed", sshKeygenCommand)
+	}
+
+	context, cleanup := createTestInstallContext(t, "desktop:latest")
+	defer cleanup()
+
+	previousKeys, previousHostKeys, previousTypes := DefaultSSHHostKeysPath, hostSSHHostKeysPath, sshHostKeyTypes
+	DefaultSSHHostKeysPath = path.Join(context.HooksPath, "ssh")
+	hostSSHHostKeysPath = path.Join(context.HooksPath, "missing")
+	sshHostKeyTypes = []string{"ed25519"}
+	defer func() {
+		DefaultSSHHostKeysPath, hostSSHHostKeysPath, sshHostKeyTypes = previousKeys, previousHostKeys, previousTypes
+	}()
+
+	files, err := sshHook{}.Install(context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("unexpected files %v", files)
+	}
+	key := readTestFile(t, path.Join(context.DestinationDirectory, "ssh_host_ed25519_key"))
+
+	// The same key is used the next time.
+	if _, err = (sshHook{}).Install(context); err != nil {
+		t.Fatal(err)
+	}
+	if readTestFile(t, path.Join(DefaultSSHHostKeysPath, "ssh_host_ed25519_key")) != key {
+		t.Fatal("expected the stored key to be reused")
+	}
+}
+
+func TestSharedHostStateParallel(t *testing.T) {
+	if _, err := exec.LookPath(sshKeygenCommand); err != nil {
+		t.Skipf("%s isn't installed", sshKeygenCommand)
+	}
+
+	dir, err := ioutil.TempDir("", "darch-builtin-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	previousMachineID, previousStored, previousKeys, previousHostKeys, previousTypes := hostMachineIDLocation, DefaultMachineIDLocation, DefaultSSHHostKeysPath, hostSSHHostKeysPath, sshHostKeyTypes
+	hostMachineIDLocation = path.Join(dir, "missing")
+	DefaultMachineIDLocation = path.Join(dir, "machine-id")
+	DefaultSSHHostKeysPath = path.Join(dir, "ssh")
+	hostSSHHostKeysPath = path.Join(dir, "missing")
+	sshHostKeyTypes = []string{"ed25519"}
+	defer func() {
+		hostMachineIDLocation, DefaultMachineIDLocation, DefaultSSHHostKeysPath, hostSSHHostKeysPath, sshHostKeyTypes = previousMachineID, previousStored, previousKeys, previousHostKeys, previousTypes
+	}()
+
+	// Install the hooks for several images at once, like RunAllHooks does, with nothing stored yet.
+	const images = 8
+	contexts := make([]InstallContext, images)
+	errs := make(chan error, images)
+	var wg sync.WaitGroup
+	for i := range contexts {
+		contexts[i] = InstallContext{
+			HooksPath:            path.Join(dir, "hooks"),
+			DestinationDirectory: path.Join(dir, fmt.Sprintf("dest%d", i)),
+			Output:               ioutil.Discard,
+		}
+		for _, d := range []string{contexts[i].HooksPath, contexts[i].DestinationDirectory} {
+			if err = os.MkdirAll(d, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+		}
+		wg.Add(1)
+		go func(context InstallContext) {
+			defer wg.Done()
+			if _, err := (machineIDHook{}).Install(context); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := (sshHook{}).Install(context); err != nil {
+				errs <- err
+			}
+		}(contexts[i])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{"machine-id", "ssh_host_ed25519_key", "ssh_host_ed25519_key.pub"} {
+		expected := readTestFile(t, path.Join(contexts[0].DestinationDirectory, file))
+		for _, context := range contexts[1:] {
+			if actual := readTestFile(t, path.Join(context.DestinationDirectory, file)); actual != expected {
+				t.Fatalf("expected every image to get the same %s", file)
+			}
+		}
+	}
+}
+
+func TestGetHooksBuiltin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "darch-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	previousPath, previousConfig := DefaultHooksPath, DefaultHooksConfigLocation
+	DefaultHooksPath = dir
+	DefaultHooksConfigLocation = path.Join(dir, "hooks-config.json")
+	defer func() { DefaultHooksPath, DefaultHooksConfigLocation = previousPath, previousConfig }()
+
+	// A hook directory replaces the built-in hook.
+	if err = os.MkdirAll(path.Join(dir, "ssh"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	// Built-in hooks are off until they are enabled in the configuration.
+	hooks, err := GetHooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := getHookNames(hooks); names != "ssh" {
+		t.Fatalf("unexpected hooks %s", names)
+	}
+	if _, err = GetHook("hostname"); err == nil {
+		t.Fatal("expected an error for a built-in hook that isn't enabled")
+	}
+
+	err = ioutil.WriteFile(DefaultHooksConfigLocation, []byte(`{"_default": {"include-images": ["*"]}, "hostname": {}, "machine-id": {}, "ssh": {}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hooks, err = GetHooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := getHookNames(hooks); names != "hostname machine-id ssh" {
+		t.Fatalf("unexpected hooks %s", names)
+	}
+	if _, err = GetHook("hostname"); err != nil {
+		t.Fatal(err)
+	}
+	for _, hook := range hooks {
+		if (hook.Name == "ssh") != (hook.Builtin == nil) {
+			t.Fatalf("unexpected implementation for %s", hook.Name)
+		}
+	}
+}
+
+func TestInstallBuiltinHook(t *testing.T) {
+	context, cleanup := createTestInstallContext(t, "desktop:latest")
+	defer cleanup()
+
+	if err := ioutil.WriteFile(path.Join(context.HooksPath, "hostname.config"), []byte("*=desktop\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := InstallBuiltinHook(Hook{Name: "hostname", Builtin: hostnameHook{}}, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := LoadManifest(context.DestinationDirectory)
+	if err != nil || manifest == nil || len(manifest.Files) != 1 {
+		t.Fatalf("unexpected manifest %v %v", manifest, err)
+	}
+
+	// The hook script copies the files into the rootfs, like the initramfs does at boot.
+	rootfs := path.Join(context.HooksPath, "rootfs")
+	cmd := exec.Command("/bin/sh", "-c", ". ./hook && run")
+	cmd.Dir = context.DestinationDirectory
+	cmd.Env = append(os.Environ(), "DARCH_HOOK_DIR="+context.DestinationDirectory, "DARCH_ROOT_FS="+rootfs)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, output)
+	}
+	if !utils.FileExists(path.Join(rootfs, "etc/hostname")) || readTestFile(t, path.Join(rootfs, "etc/hostname")) != "desktop\n" {
+		t.Fatal("expected the hostname in the rootfs")
+	}
+}
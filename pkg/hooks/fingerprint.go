@@ -0,0 +1,41 @@
+package hooks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/godarch/darch/pkg/utils"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// GetHooksFingerprint Get a digest of everything in DefaultHooksPath (the hooks and their configuration),
+// which changes when any file is added, removed or modified.
+func GetHooksFingerprint() (string, error) {
+	digester := digest.Canonical.Digester()
+	if !utils.DirectoryExists(DefaultHooksPath) {
+		return digester.Digest().String(), nil
+	}
+
+	err := filepath.Walk(DefaultHooksPath, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(DefaultHooksPath, file)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// The modification time of a directory changes with the files in it, which are already accounted for.
+			_, err = fmt.Fprintf(digester.Hash(), "%s %v\n", relativePath, info.Mode())
+			return err
+		}
+		_, err = fmt.Fprintf(digester.Hash(), "%s %v %d %d\n", relativePath, info.Mode(), info.Size(), info.ModTime().UnixNano())
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return digester.Digest().String(), nil
+}
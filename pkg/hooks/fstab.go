@@ -0,0 +1,48 @@
+package hooks
+
+import (
+	"fmt"
+	"path"
+)
+
+// fstabHook Sets the fstab of the image.
+// The fstab is the file named by the "fstab" param of the hook, relative to the hooks directory.
+// ---------
+// "fstab": {"params": {"fstab": "default_fstab"}, "image-params": [{"image": "desktop:*", "params": {"fstab": "desktop_fstab"}}]}
+// ---------
+type fstabHook struct{}
+
+func (fstabHook) Name() string {
+	return "fstab"
+}
+
+func (fstabHook) Help() string {
+	return "Sets /etc/fstab, using the file named by the fstab param (relative to the hooks directory)."
+}
+
+func (hook fstabHook) Install(context InstallContext) ([]ManifestFile, error) {
+	fstabFile, matched, err := getBuiltinHookParam(context, "fstab", "fstab.config")
+	if err != nil {
+		return nil, err
+	}
+	if !matched {
+		fmt.Fprintf(context.Output, "no fstab param for %s, not using any fstab\n", context.ImageRef.FullName())
+		return []ManifestFile{}, nil
+	}
+
+	if !path.IsAbs(fstabFile) {
+		fstabFile = path.Join(context.HooksPath, fstabFile)
+	}
+
+	exists, err := copyHostFile(fstabFile, context.DestinationDirectory, "fstab")
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("the fstab %s for %s doesn't exist", fstabFile, context.ImageRef.FullName())
+	}
+
+	return []ManifestFile{
+		{Source: "fstab", Destination: "/etc/fstab", Mode: "0644"},
+	}, nil
+}
@@ -8,8 +8,8 @@ import (
 	"os/exec"
 	"path"
 	"sort"
+	"strings"
 
-	"github.com/gobwas/glob"
 	"github.com/godarch/darch/pkg/reference"
 	"github.com/godarch/darch/pkg/utils"
 )
@@ -27,21 +27,55 @@ type Hook struct {
 	Path           string
 	HooksPath      string
 	ExecutionOrder int
-	NameWithOrder  string //ExecutionOrder_Name
-	IncludeImages  []string
-	ExcludeImages  []string
+	NameWithOrder  string //ResolvedOrder_Name
+	// ResolvedOrder The position of the hook, after resolving After/Before, with ExecutionOrder as a tie breaker.
+	ResolvedOrder int
+	IncludeImages []string
+	ExcludeImages []string
+	// After The hooks that must run before this one, if they exist.
+	After []string
+	// Before The hooks that must run after this one, if they exist.
+	Before []string
+	// RequiresCommands The commands (in PATH) that must exist on the host for this hook.
+	RequiresCommands []string
+	// RequiresFiles The files that must exist on the host for this hook.
+	RequiresFiles []string
+	// Params Settings for the hook, passed to it as DARCH_HOOK_PARAM_<NAME>.
+	Params map[string]string
+	// ImageParams Settings for the images matching a glob, overriding Params.
+	ImageParams []ImageParams
+	// Builtin The implementation of a built-in hook. Nil for hooks in DefaultHooksPath.
+	Builtin BuiltinHook
+}
+
+// ImageParams Hook settings for every image matching a glob.
+type ImageParams struct {
+	Image  string            `json:"image"`
+	Params map[string]string `json:"params"`
 }
 
 type hookConfiguration struct {
-	ExecutionOrder int
-	IncludeImages  []string
-	ExcludeImages  []string
+	ExecutionOrder   int
+	IncludeImages    []string
+	ExcludeImages    []string
+	After            []string
+	Before           []string
+	RequiresCommands []string
+	RequiresFiles    []string
+	Params           map[string]string
+	ImageParams      []ImageParams
 }
 
 type hookConfigurationJSON struct {
-	ExecutionOrder *int      `json:"execution-order"`
-	IncludeImages  *[]string `json:"include-images"`
-	ExcludeImages  *[]string `json:"exclude-images"`
+	ExecutionOrder   *int               `json:"execution-order"`
+	IncludeImages    *[]string          `json:"include-images"`
+	ExcludeImages    *[]string          `json:"exclude-images"`
+	After            *[]string          `json:"after"`
+	Before           *[]string          `json:"before"`
+	RequiresCommands *[]string          `json:"requires-commands"`
+	RequiresFiles    *[]string          `json:"requires-files"`
+	Params           *map[string]string `json:"params"`
+	ImageParams      *[]ImageParams     `json:"image-params"`
 }
 
 func buildDefaultHookEntry() hookConfiguration {
@@ -50,7 +84,13 @@ func buildDefaultHookEntry() hookConfiguration {
 		IncludeImages: []string{
 			"*",
 		},
-		ExcludeImages: []string{},
+		ExcludeImages:    []string{},
+		After:            []string{},
+		Before:           []string{},
+		RequiresCommands: []string{},
+		RequiresFiles:    []string{},
+		Params:           map[string]string{},
+		ImageParams:      []ImageParams{},
 	}
 }
 
@@ -64,6 +104,9 @@ func getHooksConfiguration() (map[string]hookConfiguration, error) {
 	}
 
 	jsonData, err := ioutil.ReadFile(DefaultHooksConfigLocation)
+	if err != nil {
+		return nil, err
+	}
 
 	result := map[string]hookConfiguration{}
 	jsonDesrialized := map[string]hookConfigurationJSON{}
@@ -86,6 +129,9 @@ func getHooksConfiguration() (map[string]hookConfiguration, error) {
 		if defaultEntrySerialized.ExcludeImages != nil {
 			defaultEntry.ExcludeImages = *defaultEntrySerialized.ExcludeImages
 		}
+		// Relationships only make sense for a single hook. Given to every hook, a hook would be ordered
+		// after itself, so they are ignored here.
+		applyHookParams(&defaultEntry, defaultEntrySerialized)
 	}
 	result["_default"] = defaultEntry
 
@@ -104,12 +150,38 @@ func getHooksConfiguration() (map[string]hookConfiguration, error) {
 		if v.ExcludeImages != nil {
 			newEntry.ExcludeImages = *v.ExcludeImages
 		}
+		applyHookRelationships(&newEntry, v)
+		applyHookParams(&newEntry, v)
 		result[k] = newEntry
 	}
 
 	return result, nil
 }
 
+func applyHookRelationships(entry *hookConfiguration, serialized hookConfigurationJSON) {
+	if serialized.After != nil {
+		entry.After = *serialized.After
+	}
+	if serialized.Before != nil {
+		entry.Before = *serialized.Before
+	}
+	if serialized.RequiresCommands != nil {
+		entry.RequiresCommands = *serialized.RequiresCommands
+	}
+	if serialized.RequiresFiles != nil {
+		entry.RequiresFiles = *serialized.RequiresFiles
+	}
+}
+
+func applyHookParams(entry *hookConfiguration, serialized hookConfigurationJSON) {
+	if serialized.Params != nil {
+		entry.Params = *serialized.Params
+	}
+	if serialized.ImageParams != nil {
+		entry.ImageParams = *serialized.ImageParams
+	}
+}
+
 // GetHook Get a hook by a name
 func GetHook(name string) (Hook, error) {
 	result := Hook{}
@@ -118,64 +190,73 @@ func GetHook(name string) (Hook, error) {
 		return result, fmt.Errorf("a name is required")
 	}
 
-	result.Name = name
-	result.HooksPath = DefaultHooksPath
-	result.Path = path.Join(result.HooksPath, name)
-
-	if !utils.DirectoryExists(result.Path) {
+	if _, ok := builtinHooks[name]; !ok && !utils.DirectoryExists(path.Join(DefaultHooksPath, name)) {
 		return result, fmt.Errorf("the hook %s doesn't exist", name)
 	}
 
-	configuration, err := getHooksConfiguration()
+	if !utils.DirectoryExists(path.Join(DefaultHooksPath, name)) {
+		configuration, err := getHooksConfiguration()
+		if err != nil {
+			return result, err
+		}
+		if _, ok := configuration[name]; !ok {
+			return result, fmt.Errorf("the built-in hook %s isn't enabled, add an entry for it to %s", name, DefaultHooksConfigLocation)
+		}
+	}
 
+	// The order of a hook depends on every other hook.
+	hooks, err := GetHooks()
 	if err != nil {
 		return result, err
 	}
 
-	var config hookConfiguration
-	if val, ok := configuration[name]; ok {
-		config = val
-	} else {
-		config = configuration["_default"]
+	for _, hook := range hooks {
+		if hook.Name == name {
+			return hook, nil
+		}
 	}
 
-	result.ExecutionOrder = config.ExecutionOrder
-	result.IncludeImages = config.IncludeImages
-	result.ExcludeImages = config.ExcludeImages
-	result.NameWithOrder = fmt.Sprintf("%08d_%s", result.ExecutionOrder, result.Name)
-
-	return result, nil
+	return result, fmt.Errorf("the hook %s doesn't exist", name)
 }
 
-// GetHooks Get all the available hooks
+// GetHooks Get all the available hooks, in the order they should be ran.
 func GetHooks() ([]Hook, error) {
-	result := make(map[string]Hook, 0)
-
 	configuration, err := getHooksConfiguration()
 
 	if err != nil {
 		return nil, err
 	}
 
-	if !utils.DirectoryExists(DefaultHooksPath) {
-		return nil, nil
+	hookNames := []string{}
+	if utils.DirectoryExists(DefaultHooksPath) {
+		hookNames, err = utils.GetChildDirectories(DefaultHooksPath)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	hooks, err := utils.GetChildDirectories(DefaultHooksPath)
-
-	if err != nil {
-		return nil, err
+	// A built-in hook is only used once it has its own entry in the configuration, "_default" doesn't enable it.
+	// A hook directory replaces the built-in hook with the same name.
+	for _, builtinName := range getBuiltinHookNames() {
+		if _, ok := configuration[builtinName]; !ok {
+			continue
+		}
+		if !utils.Contains(hookNames, builtinName) {
+			hookNames = append(hookNames, builtinName)
+		}
 	}
 
-	// This is used for sorting later
-	executionOrderGroup := make(map[int][]string)
-
-	for _, hook := range hooks {
+	hooks := make([]Hook, 0)
+	for _, hookName := range hookNames {
 		newHook := Hook{
-			Name:      hook,
+			Name:      hookName,
 			HooksPath: DefaultHooksPath,
 		}
-		newHook.Path = path.Join(newHook.HooksPath, newHook.Name)
+		if utils.DirectoryExists(path.Join(newHook.HooksPath, newHook.Name)) {
+			newHook.Path = path.Join(newHook.HooksPath, newHook.Name)
+		} else {
+			newHook.Builtin = builtinHooks[newHook.Name]
+		}
 		var config hookConfiguration
 		if val, ok := configuration[newHook.Name]; ok {
 			config = val
@@ -185,59 +266,104 @@ func GetHooks() ([]Hook, error) {
 		newHook.ExecutionOrder = config.ExecutionOrder
 		newHook.IncludeImages = config.IncludeImages
 		newHook.ExcludeImages = config.ExcludeImages
-		newHook.NameWithOrder = fmt.Sprintf("%08d_%s", newHook.ExecutionOrder, newHook.Name)
-		result[newHook.Name] = newHook
-
-		if value, ok := executionOrderGroup[newHook.ExecutionOrder]; ok {
-			executionOrderGroup[newHook.ExecutionOrder] = append(value, newHook.Name)
-		} else {
-			executionOrderGroup[newHook.ExecutionOrder] = []string{
-				newHook.Name,
-			}
-		}
+		newHook.After = config.After
+		newHook.Before = config.Before
+		newHook.RequiresCommands = config.RequiresCommands
+		newHook.RequiresFiles = config.RequiresFiles
+		newHook.Params = config.Params
+		newHook.ImageParams = config.ImageParams
+		hooks = append(hooks, newHook)
 	}
 
-	// Store the items in [ExecutionOrder->Name] order
-	var executionOrderGroupKeys []int
-	resultSorted := make([]Hook, 0)
-	for k := range executionOrderGroup {
-		executionOrderGroupKeys = append(executionOrderGroupKeys, k)
-	}
-	sort.Ints(executionOrderGroupKeys)
-	for _, executionOrder := range executionOrderGroupKeys {
-		executionOrderHooks := executionOrderGroup[executionOrder]
-		sort.Strings(executionOrderHooks)
-		for _, hook := range executionOrderHooks {
-			resultSorted = append(resultSorted, result[hook])
-		}
-	}
-
-	return resultSorted, nil
+	return resolveHookOrder(hooks)
 }
 
 // AppliesToImage Determines if a hook applies to the given image.
-func AppliesToImage(hook Hook, imageRef reference.ImageRef) bool {
+// Returns an error if the include-images or exclude-images of the hook have an invalid glob.
+func AppliesToImage(hook Hook, imageRef reference.ImageRef) (bool, error) {
 	// First, let's see if we globbed the image
 	for _, includeImage := range hook.IncludeImages {
-		g := glob.MustCompile(includeImage)
-		if g.Match(imageRef.FullName()) {
+		included, err := MatchesImage(includeImage, imageRef)
+		if err != nil {
+			return false, fmt.Errorf("invalid glob %s in include-images of %s: %v", includeImage, hook.Name, err)
+		}
+		if included {
 			// This image has been included, but now, let's see if we excluded it
 			for _, excludeImage := range hook.ExcludeImages {
-				g = glob.MustCompile(excludeImage)
-				if g.Match(imageRef.FullName()) {
+				excluded, err := MatchesImage(excludeImage, imageRef)
+				if err != nil {
+					return false, fmt.Errorf("invalid glob %s in exclude-images of %s: %v", excludeImage, hook.Name, err)
+				}
+				if excluded {
 					// Someone doesn't want to apply this hook to this tag!
-					return false
+					return false, nil
 				}
 			}
 			// Nobody excluded us (after inclusion), so we are clear!
-			return true
+			return true, nil
 		}
 	}
-	return false
+	return false, nil
+}
+
+// GetHookParams Get the settings of a hook for an image. The params of every
+// image-params entry matching the image override Params, in the order they are configured.
+func GetHookParams(hook Hook, imageRef reference.ImageRef) (map[string]string, error) {
+	result := make(map[string]string)
+	for key, value := range hook.Params {
+		result[key] = value
+	}
+	for _, imageParams := range hook.ImageParams {
+		matches, err := MatchesImage(imageParams.Image, imageRef)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %s in image-params of %s: %v", imageParams.Image, hook.Name, err)
+		}
+		if !matches {
+			continue
+		}
+		for key, value := range imageParams.Params {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+// GetParamsEnvironment Get the environment variables (DARCH_HOOK_PARAM_<NAME>) for the settings of a hook.
+func GetParamsEnvironment(params map[string]string) []string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, fmt.Sprintf("DARCH_HOOK_PARAM_%s=%s", EnvironmentName(key), params[key]))
+	}
+	return result
+}
+
+// EnvironmentName Converts a name (org.opencontainers.image.version) into a valid environment variable name (ORG_OPENCONTAINERS_IMAGE_VERSION).
+func EnvironmentName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			return r
+		default:
+			return '_'
+		}
+	}, name)
 }
 
 // PrintHookHelp Print the help for a hook
 func PrintHookHelp(hook Hook) error {
+	if hook.Builtin != nil {
+		fmt.Println(hook.Builtin.Help())
+		return nil
+	}
+
 	hookFile := path.Join(hook.Path, "hook")
 	if !utils.FileExists(hookFile) {
 		return fmt.Errorf("Hook script %s doesn't exist", hookFile)
@@ -0,0 +1,301 @@
+package hooks
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/godarch/darch/pkg/block"
+	"github.com/godarch/darch/pkg/reference"
+)
+
+func getHookNames(hooks []Hook) string {
+	names := []string{}
+	for _, hook := range hooks {
+		names = append(names, hook.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestResolveHookOrderByExecutionOrder(t *testing.T) {
+	result, err := resolveHookOrder([]Hook{
+		{Name: "ssh", ExecutionOrder: 1},
+		{Name: "fstab", ExecutionOrder: 0},
+		{Name: "hostname", ExecutionOrder: 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := getHookNames(result); names != "fstab hostname ssh" {
+		t.Fatalf("unexpected order %s", names)
+	}
+	for i, hook := range result {
+		if hook.ResolvedOrder != i {
+			t.Fatalf("expected resolved order %d for %s, got %d", i, hook.Name, hook.ResolvedOrder)
+		}
+	}
+	if result[2].NameWithOrder != "00000002_ssh" {
+		t.Fatalf("unexpected name with order %s", result[2].NameWithOrder)
+	}
+}
+
+func TestResolveHookOrderWithRelationships(t *testing.T) {
+	result, err := resolveHookOrder([]Hook{
+		{Name: "a", ExecutionOrder: 0, After: []string{"c"}},
+		{Name: "b", ExecutionOrder: 0},
+		{Name: "c", ExecutionOrder: 5},
+		{Name: "d", ExecutionOrder: 10, Before: []string{"b", "not-installed"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := getHookNames(result); names != "c a d b" {
+		t.Fatalf("unexpected order %s", names)
+	}
+}
+
+func TestResolveHookOrderCycle(t *testing.T) {
+	_, err := resolveHookOrder([]Hook{
+		{Name: "a", After: []string{"b"}},
+		{Name: "b", After: []string{"c"}},
+		{Name: "c", After: []string{"a"}},
+		{Name: "d"},
+	})
+	if err == nil {
+		t.Fatal("expected an error for a cycle")
+	}
+	if !strings.Contains(err.Error(), "a, b, c") {
+		t.Fatalf("expected the cycle to be reported, got %v", err)
+	}
+}
+
+func TestUnsatisfiedRequirements(t *testing.T) {
+	unsatisfied := GetUnsatisfiedRequirements(Hook{
+		RequiresCommands: []string{"sh", "darch-command-that-does-not-exist"},
+		RequiresFiles:    []string{"/", "/darch/file/that/does/not/exist"},
+	})
+	if strings.Join(unsatisfied, " ") != "darch-command-that-does-not-exist /darch/file/that/does/not/exist" {
+		t.Fatalf("unexpected requirements %v", unsatisfied)
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "darch-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest, err := LoadManifest(dir)
+	if err != nil || manifest != nil {
+		t.Fatalf("expected no manifest, got %v %v", manifest, err)
+	}
+
+	if err = ioutil.WriteFile(path.Join(dir, "hostname"), []byte("desktop\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for manifestJSON, valid := range map[string]bool{
+		`{"files": [{"source": "hostname", "destination": "/etc/hostname", "mode": "0644"}]}`: true,
+		`{"files": [{"source": "missing", "destination": "/etc/hostname"}]}`:                  false,
+		`{"files": [{"source": "../hostname", "destination": "/etc/hostname"}]}`:              false,
+		`{"files": [{"source": "hostname", "destination": "etc/hostname"}]}`:                  false,
+		`{"files": [{"source": "hostname", "destination": "/etc/hostname", "mode": "rw"}]}`:   false,
+	} {
+		if err = ioutil.WriteFile(path.Join(dir, ManifestFileName), []byte(manifestJSON), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err = LoadManifest(dir)
+		if valid && err != nil {
+			t.Fatalf("expected %s to be valid: %v", manifestJSON, err)
+		}
+		if !valid && err == nil {
+			t.Fatalf("expected %s to be invalid", manifestJSON)
+		}
+	}
+}
+
+func TestGetSandboxReadOnlyMounts(t *testing.T) {
+	mounts := []block.Mount{
+		{MountPoint: "/home"},
+		{MountPoint: "/dev"},
+		{MountPoint: "/"},
+		{MountPoint: "/proc/sys/fs/binfmt_misc"},
+		{MountPoint: "/var/lib/darch"},
+		{MountPoint: "/boot"},
+		{MountPoint: "/devices"},
+		{MountPoint: "/home"},
+	}
+	result := strings.Join(getSandboxReadOnlyMounts(mounts), " ")
+	if result != "/ /home /boot /devices /var/lib/darch" {
+		t.Fatalf("unexpected mounts %s", result)
+	}
+}
+
+func useTestHooksPath(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "darch-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousPath, previousConfig := DefaultHooksPath, DefaultHooksConfigLocation
+	DefaultHooksPath = dir
+	DefaultHooksConfigLocation = path.Join(dir, "hooks-config.json")
+	return func() {
+		DefaultHooksPath, DefaultHooksConfigLocation = previousPath, previousConfig
+		os.RemoveAll(dir)
+	}
+}
+
+func TestNewHookIsValid(t *testing.T) {
+	defer useTestHooksPath(t)()
+
+	if err := ioutil.WriteFile(DefaultHooksConfigLocation, []byte(`{"_default": {"execution-order": 1}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewHook("example"); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewHook("example"); err == nil {
+		t.Fatal("expected an error for an existing hook")
+	}
+	if err := NewHook("../example"); err == nil {
+		t.Fatal("expected an error for an invalid name")
+	}
+
+	configuration, err := getHooksConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configuration["_default"].ExecutionOrder != 1 || len(configuration["example"].IncludeImages) != 1 {
+		t.Fatalf("unexpected configuration %v", configuration)
+	}
+
+	problems, err := ValidateHooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+}
+
+func TestValidateHooks(t *testing.T) {
+	defer useTestHooksPath(t)()
+
+	if err := os.MkdirAll(path.Join(DefaultHooksPath, "noscript"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(path.Join(DefaultHooksPath, "norun"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(DefaultHooksPath, "norun", "hook"), []byte("help() {\n  true\n}\nfunction install {\n  true\n}\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(DefaultHooksConfigLocation, []byte(`{"norun": {"include-images": ["[a-"]}, "missing": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := ValidateHooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := []string{}
+	for _, problem := range problems {
+		result = append(result, problem.Hook)
+	}
+	if strings.Join(result, " ") != "norun noscript missing norun" {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+
+	imageRef, _ := reference.ParseImage("desktop:latest")
+	if _, err = AppliesToImage(Hook{Name: "norun", IncludeImages: []string{"[a-"}}, imageRef); err == nil {
+		t.Fatal("expected an error for an invalid glob")
+	}
+}
+
+func TestDefaultHookRelationships(t *testing.T) {
+	defer useTestHooksPath(t)()
+
+	err := ioutil.WriteFile(DefaultHooksConfigLocation, []byte(`{"_default": {"after": ["fstab"], "before": ["ssh"], "params": {"domain": "local"}}, "fstab": {}, "hostname": {"after": ["fstab"]}, "ssh": {}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fstab would be after itself (and ssh before itself) if _default's relationships were given to every hook.
+	hooks, err := GetHooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hook := range hooks {
+		if hook.Params["domain"] != "local" {
+			t.Fatalf("expected %s to get the params of _default", hook.Name)
+		}
+		if hook.Name == "hostname" {
+			if strings.Join(hook.After, " ") != "fstab" {
+				t.Fatalf("expected hostname to keep its own relationships, got %v", hook.After)
+			}
+		} else if len(hook.After) != 0 || len(hook.Before) != 0 {
+			t.Fatalf("expected %s to have no relationships, got %v %v", hook.Name, hook.After, hook.Before)
+		}
+	}
+
+	problems, err := ValidateHooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].Hook != "_default" {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+}
+
+func TestGetHookParams(t *testing.T) {
+	hook := Hook{
+		Name:   "hostname",
+		Params: map[string]string{"hostname": "workstation", "domain": "local"},
+		ImageParams: []ImageParams{
+			{Image: "desktop:*", Params: map[string]string{"hostname": "desktop"}},
+			{Image: "*:test", Params: map[string]string{"hostname": "test"}},
+		},
+	}
+
+	for image, expected := range map[string]string{
+		"server:latest":  "DARCH_HOOK_PARAM_DOMAIN=local DARCH_HOOK_PARAM_HOSTNAME=workstation",
+		"desktop:latest": "DARCH_HOOK_PARAM_DOMAIN=local DARCH_HOOK_PARAM_HOSTNAME=desktop",
+		"desktop:test":   "DARCH_HOOK_PARAM_DOMAIN=local DARCH_HOOK_PARAM_HOSTNAME=test",
+	} {
+		imageRef, err := reference.ParseImage(image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		params, err := GetHookParams(hook, imageRef)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := strings.Join(GetParamsEnvironment(params), " "); actual != expected {
+			t.Fatalf("expected %s for %s, got %s", expected, image, actual)
+		}
+	}
+}
+
+func TestGetHooksFingerprint(t *testing.T) {
+	defer useTestHooksPath(t)()
+
+	fingerprint, err := GetHooksFingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if current, _ := GetHooksFingerprint(); current != fingerprint {
+		t.Fatal("expected the fingerprint to stay the same")
+	}
+
+	if err = ioutil.WriteFile(DefaultHooksConfigLocation, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := GetHooksFingerprint(); current == fingerprint {
+		t.Fatal("expected the fingerprint to change")
+	}
+}
@@ -0,0 +1,103 @@
+package hooks
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/godarch/darch/pkg/reference"
+)
+
+var (
+	hostHostnameLocation = "/etc/hostname"
+)
+
+// hostnameHook Sets the hostname of the image.
+// The hostname is the "hostname" param of the hook, which is a template.
+// ---------
+// "hostname": {"params": {"hostname": "workstation"}, "image-params": [{"image": "desktop:*", "params": {"hostname": "{{.BaseName}}-{{.Tag}}"}}]}
+// ---------
+// Without the param (or a legacy hostname.config), the hostname of this machine is used.
+type hostnameHook struct{}
+
+// hostnameTemplateData The values available to a hostname template.
+type hostnameTemplateData struct {
+	// Name The name of the image (pauldotknopf/desktop).
+	Name string
+	// BaseName The last part of the name of the image (desktop).
+	BaseName string
+	Tag      string
+	Domain   string
+	// Hostname The hostname of this machine.
+	Hostname string
+}
+
+func (hostnameHook) Name() string {
+	return "hostname"
+}
+
+func (hostnameHook) Help() string {
+	return "Sets /etc/hostname, using the hostname param (a template, such as {{.BaseName}}-{{.Tag}}), or the hostname of this machine."
+}
+
+func (hook hostnameHook) Install(context InstallContext) ([]ManifestFile, error) {
+	hostHostname := ""
+	if data, err := ioutil.ReadFile(hostHostnameLocation); err == nil {
+		hostHostname = strings.TrimSpace(string(data))
+	}
+
+	hostnameTemplate, matched, err := getBuiltinHookParam(context, "hostname", "hostname.config")
+	if err != nil {
+		return nil, err
+	}
+	if !matched {
+		if len(hostHostname) == 0 {
+			fmt.Fprintf(context.Output, "no hostname param or %s, not setting a hostname\n", hostHostnameLocation)
+			return []ManifestFile{}, nil
+		}
+		hostnameTemplate = hostHostname
+	}
+
+	hostname, err := renderHostname(hostnameTemplate, context.ImageRef, hostHostname)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(path.Join(context.DestinationDirectory, "hostname"), []byte(hostname+"\n"), 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return []ManifestFile{
+		{Source: "hostname", Destination: "/etc/hostname", Mode: "0644"},
+	}, nil
+}
+
+func renderHostname(hostnameTemplate string, imageRef reference.ImageRef, hostHostname string) (string, error) {
+	t, err := template.New("hostname").Option("missingkey=error").Parse(hostnameTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid hostname template %s: %v", hostnameTemplate, err)
+	}
+
+	var b bytes.Buffer
+	err = t.Execute(&b, hostnameTemplateData{
+		Name:     imageRef.Name(),
+		BaseName: path.Base(imageRef.Name()),
+		Tag:      imageRef.Tag(),
+		Domain:   imageRef.Domain(),
+		Hostname: hostHostname,
+	})
+	if err != nil {
+		return "", fmt.Errorf("invalid hostname template %s: %v", hostnameTemplate, err)
+	}
+
+	hostname := strings.TrimSpace(b.String())
+	if len(hostname) == 0 || len(hostname) > 64 || strings.ContainsAny(hostname, " \t\n/") {
+		return "", fmt.Errorf("invalid hostname \"%s\" for %s", hostname, imageRef.FullName())
+	}
+
+	return hostname, nil
+}
@@ -0,0 +1,34 @@
+package hooks
+
+import (
+	"github.com/gobwas/glob"
+	"github.com/godarch/darch/pkg/reference"
+)
+
+// ImageGlob A glob matched against the full name (name:tag) of images, like the include-images of a hook.
+type ImageGlob struct {
+	g glob.Glob
+}
+
+// CompileImageGlob Compiles a glob for matching images.
+func CompileImageGlob(pattern string) (ImageGlob, error) {
+	g, err := glob.Compile(pattern)
+	if err != nil {
+		return ImageGlob{}, err
+	}
+	return ImageGlob{g: g}, nil
+}
+
+// Matches Determines if the glob matches the image.
+func (imageGlob ImageGlob) Matches(imageRef reference.ImageRef) bool {
+	return imageGlob.g.Match(imageRef.FullName())
+}
+
+// MatchesImage Compiles a glob, and determines if it matches the image.
+func MatchesImage(pattern string, imageRef reference.ImageRef) (bool, error) {
+	imageGlob, err := CompileImageGlob(pattern)
+	if err != nil {
+		return false, err
+	}
+	return imageGlob.Matches(imageRef), nil
+}
@@ -0,0 +1,32 @@
+package hooks
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// hostStateLock Guards the state built-in hooks share between images (ssh host keys, machine-id) within this process.
+// Hooks for several images are installed at the same time, so the first ones would otherwise each generate their own.
+var hostStateLock sync.Mutex
+
+// withHostStateLock Runs f while holding hostStateLock, and an flock on lockFile to keep other processes out.
+func withHostStateLock(lockFile string, f func() error) error {
+	hostStateLock.Lock()
+	defer hostStateLock.Unlock()
+
+	l, err := os.OpenFile(lockFile, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	err = syscall.Flock(int(l.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return fmt.Errorf("couldn't lock %s: %v", lockFile, err)
+	}
+	defer syscall.Flock(int(l.Fd()), syscall.LOCK_UN)
+
+	return f()
+}
@@ -0,0 +1,135 @@
+package hooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/godarch/darch/pkg/utils"
+)
+
+var (
+	// DefaultMachineIDLocation Where the machine-id shared by every image is stored.
+	DefaultMachineIDLocation = "/etc/darch/machine-id"
+	hostMachineIDLocation    = "/etc/machine-id"
+)
+
+// machineIDHook Gives every image the same machine-id.
+// The machine-id is stored in DefaultMachineIDLocation the first time the hook is installed,
+// taken from this machine, or generated if this machine has none.
+type machineIDHook struct{}
+
+func (machineIDHook) Name() string {
+	return "machine-id"
+}
+
+func (machineIDHook) Help() string {
+	return "Sets /etc/machine-id, so that every image shares the same machine-id."
+}
+
+func (hook machineIDHook) Install(context InstallContext) ([]ManifestFile, error) {
+	err := os.MkdirAll(path.Dir(DefaultMachineIDLocation), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	var machineID string
+	err = withHostStateLock(DefaultMachineIDLocation+".lock", func() error {
+		var err error
+		machineID, err = getStoredMachineID(context)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(path.Join(context.DestinationDirectory, "machine-id"), []byte(machineID+"\n"), 0444)
+	if err != nil {
+		return nil, err
+	}
+
+	return []ManifestFile{
+		{Source: "machine-id", Destination: "/etc/machine-id", Mode: "0444"},
+	}, nil
+}
+
+// getStoredMachineID Get the machine-id shared by every image, storing it the first time.
+// Must be called with the host state lock held.
+func getStoredMachineID(context InstallContext) (string, error) {
+	machineID, err := readMachineID(DefaultMachineIDLocation)
+	if err != nil {
+		return "", err
+	}
+
+	// The machine-id used to be stored in the hooks directory.
+	legacyMachineID := path.Join(context.HooksPath, "current-machine-id")
+	if len(machineID) == 0 {
+		machineID, err = readMachineID(legacyMachineID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if len(machineID) == 0 {
+		machineID, err = readMachineID(hostMachineIDLocation)
+		if err != nil {
+			return "", err
+		}
+		if len(machineID) == 0 {
+			fmt.Fprintf(context.Output, "no machine-id at %s, generating one\n", hostMachineIDLocation)
+			machineID, err = generateMachineID()
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if !utils.FileExists(DefaultMachineIDLocation) {
+		err = ioutil.WriteFile(DefaultMachineIDLocation, []byte(machineID+"\n"), 0444)
+		if err != nil {
+			return "", err
+		}
+	}
+	os.Remove(legacyMachineID)
+
+	return machineID, nil
+}
+
+// readMachineID Reads a machine-id, returning an empty string if it doesn't exist.
+func readMachineID(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	machineID := strings.TrimSpace(string(data))
+	if len(machineID) == 0 {
+		return "", nil
+	}
+	if len(machineID) != 32 {
+		return "", fmt.Errorf("invalid machine-id in %s", file)
+	}
+	if _, err = hex.DecodeString(machineID); err != nil {
+		return "", fmt.Errorf("invalid machine-id in %s", file)
+	}
+	return machineID, nil
+}
+
+// generateMachineID Generates a random machine-id, like systemd-machine-id-setup.
+func generateMachineID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	// Mark it as a v4 uuid, like systemd does.
+	b[6] = (b[6] & 0x0F) | 0x40
+	b[8] = (b[8] & 0x3F) | 0x80
+	return hex.EncodeToString(b), nil
+}
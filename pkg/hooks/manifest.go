@@ -0,0 +1,74 @@
+package hooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/godarch/darch/pkg/utils"
+)
+
+const (
+	// ManifestFileName The file a hook can write to its destination directory, describing what it injects into the rootfs.
+	ManifestFileName = "manifest.json"
+)
+
+// Manifest Describes the files a hook injects into the rootfs at boot.
+// ---------
+// {"files": [{"source": "hostname", "destination": "/etc/hostname", "mode": "0644"}]}
+// ---------
+type Manifest struct {
+	Files []ManifestFile `json:"files"`
+}
+
+// ManifestFile A file, in the destination directory of a hook, that is injected into the rootfs.
+type ManifestFile struct {
+	// Source The path of the file, relative to the destination directory of the hook.
+	Source string `json:"source"`
+	// Destination The absolute path of the file in the rootfs.
+	Destination string `json:"destination"`
+	// Mode The octal permissions of the file in the rootfs, if different from the source.
+	Mode string `json:"mode,omitempty"`
+}
+
+// LoadManifest Loads and validates the manifest written by a hook to its destination directory.
+// Returns nil if the hook didn't write a manifest.
+func LoadManifest(destinationDirectory string) (*Manifest, error) {
+	jsonData, err := ioutil.ReadFile(path.Join(destinationDirectory, ManifestFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	result := &Manifest{}
+	err = json.Unmarshal(jsonData, result)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", ManifestFileName, err)
+	}
+
+	for _, file := range result.Files {
+		source := path.Clean(file.Source)
+		if len(file.Source) == 0 || path.IsAbs(source) || source == ".." || strings.HasPrefix(source, "../") {
+			return nil, fmt.Errorf("invalid %s: source %s must be relative to the hook directory", ManifestFileName, file.Source)
+		}
+		if !utils.FileExists(path.Join(destinationDirectory, source)) {
+			return nil, fmt.Errorf("invalid %s: source %s doesn't exist", ManifestFileName, file.Source)
+		}
+		if !path.IsAbs(file.Destination) {
+			return nil, fmt.Errorf("invalid %s: destination %s must be absolute", ManifestFileName, file.Destination)
+		}
+		if len(file.Mode) > 0 {
+			if _, err = strconv.ParseUint(file.Mode, 8, 32); err != nil {
+				return nil, fmt.Errorf("invalid %s: mode %s must be octal", ManifestFileName, file.Mode)
+			}
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,110 @@
+package hooks
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// resolveHookOrder Sorts the hooks so that every hook runs after the hooks it declares
+// with After (or that declare it with Before). Between hooks that don't depend on each
+// other, the lower ExecutionOrder runs first, then the name decides.
+// Relationships with hooks that aren't installed are ignored.
+func resolveHookOrder(hooks []Hook) ([]Hook, error) {
+	byName := make(map[string]int)
+	for i, hook := range hooks {
+		byName[hook.Name] = i
+	}
+
+	// edges[a][b] means a must run before b.
+	edges := make(map[string]map[string]bool)
+	dependencies := make(map[string]int)
+	addEdge := func(from string, to string) {
+		if _, ok := byName[from]; !ok {
+			return
+		}
+		if _, ok := byName[to]; !ok {
+			return
+		}
+		if edges[from] == nil {
+			edges[from] = make(map[string]bool)
+		}
+		if !edges[from][to] {
+			edges[from][to] = true
+			dependencies[to]++
+		}
+	}
+	for _, hook := range hooks {
+		for _, after := range hook.After {
+			addEdge(after, hook.Name)
+		}
+		for _, before := range hook.Before {
+			addEdge(hook.Name, before)
+		}
+	}
+
+	less := func(a string, b string) bool {
+		hookA, hookB := hooks[byName[a]], hooks[byName[b]]
+		if hookA.ExecutionOrder != hookB.ExecutionOrder {
+			return hookA.ExecutionOrder < hookB.ExecutionOrder
+		}
+		return hookA.Name < hookB.Name
+	}
+
+	ready := []string{}
+	for _, hook := range hooks {
+		if dependencies[hook.Name] == 0 {
+			ready = append(ready, hook.Name)
+		}
+	}
+
+	result := make([]Hook, 0, len(hooks))
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return less(ready[i], ready[j]) })
+		name := ready[0]
+		ready = ready[1:]
+
+		hook := hooks[byName[name]]
+		hook.ResolvedOrder = len(result)
+		hook.NameWithOrder = fmt.Sprintf("%08d_%s", hook.ResolvedOrder, hook.Name)
+		result = append(result, hook)
+
+		for next := range edges[name] {
+			dependencies[next]--
+			if dependencies[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+
+	if len(result) != len(hooks) {
+		cycle := []string{}
+		for _, hook := range hooks {
+			if dependencies[hook.Name] > 0 {
+				cycle = append(cycle, hook.Name)
+			}
+		}
+		sort.Strings(cycle)
+		return nil, fmt.Errorf("the hooks %s have a circular after/before relationship", strings.Join(cycle, ", "))
+	}
+
+	return result, nil
+}
+
+// GetUnsatisfiedRequirements Get the commands and files a hook requires that don't exist on this host.
+func GetUnsatisfiedRequirements(hook Hook) []string {
+	result := []string{}
+	for _, command := range hook.RequiresCommands {
+		if _, err := exec.LookPath(command); err != nil {
+			result = append(result, command)
+		}
+	}
+	for _, file := range hook.RequiresFiles {
+		if _, err := os.Stat(file); err != nil {
+			result = append(result, file)
+		}
+	}
+	return result
+}
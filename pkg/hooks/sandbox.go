@@ -0,0 +1,130 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/godarch/darch/pkg/block"
+)
+
+const (
+	// SandboxHelperCommand The hidden command (darch helpers hook-sandbox) that sets up the sandbox, before running the hook.
+	SandboxHelperCommand = "hook-sandbox"
+	sandboxPath          = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+// sandboxWritableMounts Mounts that stay as they are in the sandbox. Device nodes, and the kernel interfaces, don't hold anything a hook could damage.
+var sandboxWritableMounts = []string{"/dev", "/proc", "/sys"}
+
+// SandboxCommand Get the command that runs a hook script in a sandbox.
+// The hook runs in a private mount (and pid) namespace, where everything but its destination directory (and a private /tmp)
+// is read-only. The environment is cleared, except for the given variables, and there is no stdin.
+// The command (and everything it started) is killed when the context is done.
+func SandboxCommand(ctx context.Context, destinationDirectory string, environment []string, script string) (*exec.Cmd, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, executable, "helpers", SandboxHelperCommand, destinationDirectory, "/bin/bash", "-c", script)
+	cmd.Env = append([]string{
+		"PATH=" + sandboxPath,
+		"HOME=/root",
+		"TMPDIR=/tmp",
+	}, environment...)
+	cmd.Dir = destinationDirectory
+	cmd.Stdin = nil
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		// When the first process of a pid namespace is killed, so is everything it started.
+		Cloneflags: syscall.CLONE_NEWNS | syscall.CLONE_NEWPID,
+	}
+	return cmd, nil
+}
+
+// EnterSandbox Sets up the mounts of the sandbox, and replaces the current process with the command.
+// This must run in the private mount namespace created by SandboxCommand.
+func EnterSandbox(destinationDirectory string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command given")
+	}
+	if !path.IsAbs(destinationDirectory) {
+		return fmt.Errorf("the destination directory must be absolute")
+	}
+
+	// Don't propagate anything we do back to the host.
+	err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+	if err != nil {
+		return fmt.Errorf("couldn't make the mounts private: %v", err)
+	}
+
+	mounts, err := block.GetMounts()
+	if err != nil {
+		return err
+	}
+	for _, mountPoint := range getSandboxReadOnlyMounts(mounts) {
+		err = syscall.Mount("", mountPoint, "", syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY, "")
+		if err != nil {
+			return fmt.Errorf("couldn't make %s read-only: %v", mountPoint, err)
+		}
+	}
+
+	err = syscall.Mount("tmpfs", "/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777")
+	if err != nil {
+		return fmt.Errorf("couldn't mount /tmp: %v", err)
+	}
+	// A bind mount starts out read-only like its source, but can be made writable on its own.
+	err = syscall.Mount(destinationDirectory, destinationDirectory, "", syscall.MS_BIND, "")
+	if err == nil {
+		err = syscall.Mount("", destinationDirectory, "", syscall.MS_REMOUNT|syscall.MS_BIND, "")
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't make %s writable: %v", destinationDirectory, err)
+	}
+	// Our working directory is still on the read-only mount beneath.
+	err = os.Chdir(destinationDirectory)
+	if err != nil {
+		return err
+	}
+
+	command, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(command, args, os.Environ())
+}
+
+// getSandboxReadOnlyMounts Get the mount points to make read-only, parents first.
+func getSandboxReadOnlyMounts(mounts []block.Mount) []string {
+	result := []string{}
+	seen := make(map[string]bool)
+	for _, m := range mounts {
+		if seen[m.MountPoint] || isSandboxWritableMount(m.MountPoint) {
+			continue
+		}
+		seen[m.MountPoint] = true
+		result = append(result, m.MountPoint)
+	}
+	depth := func(mountPoint string) int {
+		if mountPoint == "/" {
+			return 0
+		}
+		return strings.Count(mountPoint, "/")
+	}
+	sort.SliceStable(result, func(i, j int) bool { return depth(result[i]) < depth(result[j]) })
+	return result
+}
+
+func isSandboxWritableMount(mountPoint string) bool {
+	for _, writable := range sandboxWritableMounts {
+		if mountPoint == writable || strings.HasPrefix(mountPoint, writable+"/") {
+			return true
+		}
+	}
+	return false
+}
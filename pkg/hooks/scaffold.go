@@ -0,0 +1,112 @@
+package hooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+
+	"github.com/docker/docker/pkg/ioutils"
+	"github.com/godarch/darch/pkg/utils"
+)
+
+var hookNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// hookTemplate The hook script created by NewHook.
+const hookTemplate = `#!/usr/bin/env bash
+set -e
+
+# Prints what the hook does, for "darch hooks help".
+help() {
+    echo "Describe what this hook does."
+}
+
+# Ran when the hooks are installed for an image, on this machine.
+# The working directory is $DARCH_HOOK_DEST_DIR, which is carried to the image at boot.
+# Everything about the image is available as DARCH_* variables ($DARCH_IMAGE_NAME, $DARCH_STAGE_ID, ...).
+# To describe the files injected into the rootfs, write a manifest.json:
+# {"files": [{"source": "example", "destination": "/etc/example", "mode": "0644"}]}
+install() {
+    true
+}
+
+# Ran by the initramfs when the image boots, before switching to the rootfs.
+# The files written by install are in $DARCH_HOOK_DIR, and the rootfs is at $DARCH_ROOT_FS.
+run() {
+    true
+}
+`
+
+// requiredHookFunctions The functions every hook script must define.
+var requiredHookFunctions = []string{"help", "install", "run"}
+
+// NewHook Creates a hook directory with a template hook script, and an entry for it in the hooks configuration.
+func NewHook(name string) error {
+	if !hookNameRegex.MatchString(name) || name == "_default" {
+		return fmt.Errorf("invalid hook name %s", name)
+	}
+
+	hookPath := path.Join(DefaultHooksPath, name)
+	if utils.DirectoryExists(hookPath) {
+		return fmt.Errorf("the hook %s already exists", name)
+	}
+
+	configuration, err := readRawHooksConfiguration()
+	if err != nil {
+		return err
+	}
+	if _, ok := configuration[name]; ok {
+		return fmt.Errorf("the hook %s is already configured in %s", name, DefaultHooksConfigLocation)
+	}
+
+	err = os.MkdirAll(hookPath, 0755)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path.Join(hookPath, "hook"), []byte(hookTemplate), 0755)
+	if err != nil {
+		os.RemoveAll(hookPath)
+		return err
+	}
+
+	configuration[name], err = json.Marshal(map[string]interface{}{
+		"include-images": []string{"*"},
+		"exclude-images": []string{},
+	})
+	if err != nil {
+		os.RemoveAll(hookPath)
+		return err
+	}
+
+	jsonData, err := json.MarshalIndent(configuration, "", "  ")
+	if err != nil {
+		os.RemoveAll(hookPath)
+		return err
+	}
+	err = ioutils.AtomicWriteFile(DefaultHooksConfigLocation, append(jsonData, '\n'), 0644)
+	if err != nil {
+		os.RemoveAll(hookPath)
+		return err
+	}
+
+	return nil
+}
+
+// readRawHooksConfiguration Reads the hooks configuration, leaving every entry as is.
+func readRawHooksConfiguration() (map[string]json.RawMessage, error) {
+	result := map[string]json.RawMessage{}
+	jsonData, err := ioutil.ReadFile(DefaultHooksConfigLocation)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(jsonData, &result)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", DefaultHooksConfigLocation, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,106 @@
+package hooks
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+
+	"github.com/godarch/darch/pkg/utils"
+)
+
+var (
+	// DefaultSSHHostKeysPath Where the ssh host keys shared by every image are stored.
+	DefaultSSHHostKeysPath = "/etc/darch/ssh/default"
+	hostSSHHostKeysPath    = "/etc/ssh"
+	sshKeygenCommand       = "ssh-keygen"
+	sshHostKeyTypes        = []string{"rsa", "ecdsa", "ed25519"}
+)
+
+// sshHook Gives every image the same ssh host keys, so that clients don't see a different host for every image.
+// The keys of this machine are used the first time the hook is installed, and missing keys are generated with ssh-keygen.
+type sshHook struct{}
+
+func (sshHook) Name() string {
+	return "ssh"
+}
+
+func (sshHook) Help() string {
+	return "Sets the ssh host keys (/etc/ssh/ssh_host_*), so that every image shares the same keys."
+}
+
+func (hook sshHook) Install(context InstallContext) ([]ManifestFile, error) {
+	err := os.MkdirAll(DefaultSSHHostKeysPath, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []ManifestFile{}
+	for _, keyType := range sshHostKeyTypes {
+		keyName := fmt.Sprintf("ssh_host_%s_key", keyType)
+		key := path.Join(DefaultSSHHostKeysPath, keyName)
+
+		var exists bool
+		err = withHostStateLock(path.Join(DefaultSSHHostKeysPath, ".lock"), func() error {
+			var err error
+			exists, err = ensureSSHHostKey(key, keyType, context)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+
+		for _, file := range []ManifestFile{
+			{Source: keyName, Destination: path.Join("/etc/ssh", keyName), Mode: "0600"},
+			{Source: keyName + ".pub", Destination: path.Join("/etc/ssh", keyName+".pub"), Mode: "0644"},
+		} {
+			_, err = copyHostFile(path.Join(DefaultSSHHostKeysPath, file.Source), context.DestinationDirectory, file.Source)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, file)
+		}
+	}
+
+	return result, nil
+}
+
+// ensureSSHHostKey Makes sure the key (and its public key) is stored, taking it from this machine
+// or generating it. Returns false if the key couldn't be generated because ssh-keygen isn't installed.
+// Must be called with the host state lock held.
+func ensureSSHHostKey(key string, keyType string, context InstallContext) (bool, error) {
+	if utils.FileExists(key) && utils.FileExists(key+".pub") {
+		return true, nil
+	}
+
+	hostKey := path.Join(hostSSHHostKeysPath, filepath.Base(key))
+	if utils.FileExists(hostKey) && utils.FileExists(hostKey+".pub") {
+		for _, suffix := range []string{"", ".pub"} {
+			if _, err := copyHostFile(hostKey+suffix, path.Dir(key), filepath.Base(key)+suffix); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	if _, err := exec.LookPath(sshKeygenCommand); err != nil {
+		fmt.Fprintf(context.Output, "%s isn't installed, not generating a %s host key\n", sshKeygenCommand, keyType)
+		return false, nil
+	}
+
+	os.Remove(key)
+	os.Remove(key + ".pub")
+	cmd := exec.Command(sshKeygenCommand, "-q", "-t", keyType, "-N", "", "-C", "", "-f", key)
+	cmd.Stdout = context.Output
+	cmd.Stderr = context.Output
+	err := cmd.Run()
+	if err != nil {
+		return false, fmt.Errorf("couldn't generate a %s host key: %v", keyType, err)
+	}
+
+	return true, nil
+}
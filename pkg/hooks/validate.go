@@ -0,0 +1,146 @@
+package hooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"github.com/godarch/darch/pkg/utils"
+)
+
+// ValidationProblem Something wrong with a hook, or its configuration.
+type ValidationProblem struct {
+	// Hook The hook with the problem, or empty for problems with the hooks as a whole.
+	Hook    string
+	Problem string
+}
+
+func (problem ValidationProblem) String() string {
+	if len(problem.Hook) == 0 {
+		return problem.Problem
+	}
+	return fmt.Sprintf("%s: %s", problem.Hook, problem.Problem)
+}
+
+// ValidateHooks Checks every hook directory, and the hooks configuration.
+// A hook must have a hook script defining help, install and run. Every glob in the configuration must
+// compile, and the configuration must only have entries for hooks that exist.
+func ValidateHooks() ([]ValidationProblem, error) {
+	result := []ValidationProblem{}
+
+	hookNames := []string{}
+	if utils.DirectoryExists(DefaultHooksPath) {
+		var err error
+		hookNames, err = utils.GetChildDirectories(DefaultHooksPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, hookName := range hookNames {
+		for _, problem := range validateHookScript(path.Join(DefaultHooksPath, hookName, "hook")) {
+			result = append(result, ValidationProblem{Hook: hookName, Problem: problem})
+		}
+	}
+
+	configuration, err := readRawHooksConfiguration()
+	if err != nil {
+		return append(result, ValidationProblem{Problem: err.Error()}), nil
+	}
+
+	configuredNames := make([]string, 0, len(configuration))
+	for name := range configuration {
+		configuredNames = append(configuredNames, name)
+	}
+	sort.Strings(configuredNames)
+
+	for _, name := range configuredNames {
+		if name != "_default" && !utils.Contains(hookNames, name) {
+			if _, ok := builtinHooks[name]; !ok {
+				result = append(result, ValidationProblem{Hook: name, Problem: fmt.Sprintf("configured in %s, but doesn't exist", DefaultHooksConfigLocation)})
+			}
+		}
+
+		entry := hookConfigurationJSON{}
+		err = json.Unmarshal(configuration[name], &entry)
+		if err != nil {
+			result = append(result, ValidationProblem{Hook: name, Problem: fmt.Sprintf("invalid configuration: %v", err)})
+			continue
+		}
+		if name == "_default" && hasHookRelationships(entry) {
+			result = append(result, ValidationProblem{Hook: name, Problem: "after, before, requires-commands and requires-files only apply to a single hook, and are ignored in _default"})
+		}
+		for _, problem := range validateGlobs("include-images", entry.IncludeImages) {
+			result = append(result, ValidationProblem{Hook: name, Problem: problem})
+		}
+		for _, problem := range validateGlobs("exclude-images", entry.ExcludeImages) {
+			result = append(result, ValidationProblem{Hook: name, Problem: problem})
+		}
+		if entry.ImageParams != nil {
+			for _, imageParams := range *entry.ImageParams {
+				if _, err = glob.Compile(imageParams.Image); err != nil {
+					result = append(result, ValidationProblem{Hook: name, Problem: fmt.Sprintf("invalid glob %s in image-params: %v", imageParams.Image, err)})
+				}
+			}
+		}
+	}
+
+	// This also catches after/before relationships that can't be satisfied.
+	if _, err = GetHooks(); err != nil {
+		result = append(result, ValidationProblem{Problem: err.Error()})
+	}
+
+	return result, nil
+}
+
+func hasHookRelationships(entry hookConfigurationJSON) bool {
+	return entry.After != nil || entry.Before != nil || entry.RequiresCommands != nil || entry.RequiresFiles != nil
+}
+
+func validateGlobs(field string, globs *[]string) []string {
+	result := []string{}
+	if globs == nil {
+		return result
+	}
+	for _, pattern := range *globs {
+		if _, err := glob.Compile(pattern); err != nil {
+			result = append(result, fmt.Sprintf("invalid glob %s in %s: %v", pattern, field, err))
+		}
+	}
+	return result
+}
+
+// validateHookScript Checks the syntax of a hook script, and that it defines the required functions.
+// The script isn't ran, since sourcing it could do anything.
+func validateHookScript(hookFile string) []string {
+	if !utils.FileExists(hookFile) {
+		return []string{"no hook script"}
+	}
+
+	data, err := ioutil.ReadFile(hookFile)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	result := []string{}
+	if output, err := exec.Command("/bin/bash", "-n", hookFile).CombinedOutput(); err != nil {
+		result = append(result, fmt.Sprintf("invalid hook script: %s", strings.TrimSpace(string(output))))
+	}
+	for _, function := range requiredHookFunctions {
+		if !definesShellFunction(string(data), function) {
+			result = append(result, fmt.Sprintf("the hook script doesn't define %s()", function))
+		}
+	}
+	return result
+}
+
+func definesShellFunction(script string, function string) bool {
+	r := regexp.MustCompile(`(?m)^\s*(function\s+` + regexp.QuoteMeta(function) + `\b|` + regexp.QuoteMeta(function) + `\s*\(\s*\))`)
+	return r.MatchString(script)
+}
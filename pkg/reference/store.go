@@ -6,6 +6,9 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/docker/docker/pkg/ioutils"
 	"github.com/godarch/darch/pkg/utils"
@@ -24,8 +27,38 @@ type Store interface {
 	Delete(ref ImageRef) (bool, error)
 	Get(ref ImageRef) (Association, error)
 	AllImages() ([]Association, error)
+	GetAnnotation(id string, key string) (string, error)
+	SetAnnotation(id string, key string, value string) error
+	DeleteAnnotations(id string) error
+	AnnotatedIDs() []string
+	GetMetadata(id string) (ImageMetadata, error)
+	UpdateMetadata(id string, update func(metadata *ImageMetadata)) error
+	DeleteMetadata(id string) error
+	MetadataIDs() []string
 }
 
+// ImageMetadata What is known about a stage, besides its names.
+type ImageMetadata struct {
+	// SourceDigest The digest of the image the stage was extracted from.
+	SourceDigest string    `json:"source-digest,omitempty"`
+	UploadedAt   time.Time `json:"uploaded-at"`
+	// UploadedBy The user that uploaded the stage.
+	UploadedBy string `json:"uploaded-by,omitempty"`
+	// UploadedWith The command that uploaded the stage.
+	UploadedWith string    `json:"uploaded-with,omitempty"`
+	HooksRanAt   time.Time `json:"hooks-ran-at"`
+	// Pinned The stage is never removed automatically.
+	Pinned bool   `json:"pinned,omitempty"`
+	Notes  string `json:"notes,omitempty"`
+}
+
+const (
+	// storeVersion The version of the json file written by this store.
+	// 1: Images and Annotations (files without a version).
+	// 2: Metadata for every stage.
+	storeVersion = 2
+)
+
 // Association An association between an id and an image.
 type Association struct {
 	ID  string
@@ -33,103 +66,191 @@ type Association struct {
 }
 
 type store struct {
-	// TODO: make this object thread safe
-	//mu sync.RWMutex
+	// mu Guards the store within this process. Other processes are kept out with an flock on lockPath.
+	mu sync.Mutex
 	// jsonPath is the path to the file where the serialized tag data is
 	// stored.
 	jsonPath string
+	// lockPath is the file locked while reading and writing jsonPath. The json file itself
+	// can't be locked, since it is replaced on every write.
+	lockPath string
 	// Images is a map of digests, mapped to image names
 	Images map[string][]string
+	// Version is the schema of the json file, see storeVersion.
+	Version int
+	// Annotations is a map of digests, mapped to key/value pairs describing the image
+	Annotations map[string]map[string]string `json:",omitempty"`
+	// Metadata is a map of digests, mapped to what is known about the image
+	Metadata map[string]ImageMetadata `json:",omitempty"`
+	// migrated is set when the json file was written with an older schema, and must be saved again.
+	migrated bool
 }
 
 // NewReferenceStore Create a new store.
+// Every operation reads the json file again, and every change is written while holding
+// an exclusive lock, so that multiple processes can use the same store.
 func NewReferenceStore(jsonPath string) (Store, error) {
 	abspath, err := filepath.Abs(jsonPath)
 	if err != nil {
 		return nil, err
 	}
 
+	parentDir := path.Dir(abspath)
+	if !utils.DirectoryExists(parentDir) {
+		err = os.MkdirAll(parentDir, os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	store := &store{
-		jsonPath: abspath,
-		Images:   make(map[string][]string),
+		jsonPath:    abspath,
+		lockPath:    abspath + ".lock",
+		Images:      make(map[string][]string),
+		Annotations: make(map[string]map[string]string),
+		Metadata:    make(map[string]ImageMetadata),
 	}
 
 	// Load the json file if it exists, otherwise create it.
-	if err := store.reload(); os.IsNotExist(err) {
-		// TODO: create parent directory, if doesn't exist.
-		parentDir := path.Join(path.Dir(jsonPath))
-		if !utils.DirectoryExists(parentDir) {
-			err = os.MkdirAll(parentDir, os.ModePerm)
-			if err != nil {
-				return nil, err
-			}
-		}
-		if err := store.save(); err != nil {
-			return nil, err
-		}
-	} else if err != nil {
+	// Files written with an older schema are migrated.
+	err = store.update(func() (bool, error) {
+		return !utils.FileExists(store.jsonPath), nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return store, nil
 }
 
-func (store *store) References(id string) ([]ImageRef, error) {
-	result := []ImageRef{}
+// read Runs a function against the current state of the json file.
+func (store *store) read(f func() error) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
 
-	images, exists := store.Images[id]
-	if !exists || images == nil {
-		return result, nil
+	unlock, err := store.lock(syscall.LOCK_SH)
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	for _, image := range images {
-		parsed, err := ParseImage(image)
-		if err != nil {
-			return result, err
-		}
-		result = append(result, parsed)
+	err = store.reload()
+	if err != nil {
+		return err
 	}
 
-	return result, nil
+	return f()
 }
 
-func (store *store) AddTag(ref ImageRef, id string, force bool) error {
-	// First, make sure it doesn't exist
-	existing, err := store.Get(ref)
-	if err == ErrDoesNotExist {
-		// This is fine
-	} else if err != nil {
+// update Runs a function against the current state of the json file, and saves the
+// changes if it returns true. No other process can change the store in between.
+func (store *store) update(f func() (bool, error)) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	unlock, err := store.lock(syscall.LOCK_EX)
+	if err != nil {
 		return err
-	} else if existing.ID == id {
-		// Already added
-		return nil
-	} else if existing.ID != id {
-		if !force {
-			return fmt.Errorf("tag already added")
+	}
+	defer unlock()
+
+	err = store.reload()
+	if err != nil {
+		return err
+	}
+
+	changed, err := f()
+	if err != nil || (!changed && !store.migrated) {
+		return err
+	}
+
+	return store.save()
+}
+
+// lock Takes an flock (syscall.LOCK_SH or syscall.LOCK_EX) on the lock file.
+func (store *store) lock(how int) (func(), error) {
+	f, err := os.OpenFile(store.lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(f.Fd()), how)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("couldn't lock %s: %v", store.lockPath, err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+func (store *store) References(id string) ([]ImageRef, error) {
+	result := []ImageRef{}
+	err := store.read(func() error {
+		images, exists := store.Images[id]
+		if !exists || images == nil {
+			return nil
 		}
-		// Delete the current reference, so we can overwrite it.
-		result, err := store.Delete(ref)
-		if err != nil {
-			return err
+
+		for _, image := range images {
+			parsed, err := ParseImage(image)
+			if err != nil {
+				return err
+			}
+			result = append(result, parsed)
 		}
-		if !result {
-			return fmt.Errorf("couldn't delete existing reference for image")
+		return nil
+	})
+	return result, err
+}
+
+func (store *store) AddTag(ref ImageRef, id string, force bool) error {
+	return store.update(func() (bool, error) {
+		// First, make sure it doesn't exist
+		existing, err := store.get(ref)
+		if err == ErrDoesNotExist {
+			// This is fine
+		} else if err != nil {
+			return false, err
+		} else if existing.ID == id {
+			// Already added
+			return false, nil
+		} else if existing.ID != id {
+			if !force {
+				return false, fmt.Errorf("tag already added")
+			}
+			// Delete the current reference, so we can overwrite it.
+			if !store.delete(ref) {
+				return false, fmt.Errorf("couldn't delete existing reference for image")
+			}
 		}
-	}
 
-	images, exists := store.Images[id]
+		images, exists := store.Images[id]
 
-	if !exists || images == nil {
-		images = []string{}
-	}
+		if !exists || images == nil {
+			images = []string{}
+		}
 
-	images = append(images, ref.FullName())
-	store.Images[id] = images
+		images = append(images, ref.FullName())
+		store.Images[id] = images
 
-	return store.save()
+		return true, nil
+	})
 }
 
 func (store *store) Delete(ref ImageRef) (bool, error) {
+	deleted := false
+	err := store.update(func() (bool, error) {
+		deleted = store.delete(ref)
+		if !deleted {
+			return false, ErrDoesNotExist
+		}
+		return true, nil
+	})
+	return deleted, err
+}
+
+func (store *store) delete(ref ImageRef) bool {
 	outerUpdated := false
 	for id, images := range store.Images {
 		updated := false
@@ -150,15 +271,20 @@ func (store *store) Delete(ref ImageRef) (bool, error) {
 			}
 		}
 	}
-
-	if !outerUpdated {
-		return false, ErrDoesNotExist
-	}
-
-	return true, store.save()
+	return outerUpdated
 }
 
 func (store *store) Get(ref ImageRef) (Association, error) {
+	result := Association{}
+	err := store.read(func() error {
+		var err error
+		result, err = store.get(ref)
+		return err
+	})
+	return result, err
+}
+
+func (store *store) get(ref ImageRef) (Association, error) {
 	for id, images := range store.Images {
 		for _, image := range images {
 			if image == ref.FullName() {
@@ -174,19 +300,140 @@ func (store *store) Get(ref ImageRef) (Association, error) {
 
 func (store *store) AllImages() ([]Association, error) {
 	result := []Association{}
-	for id, images := range store.Images {
-		for _, image := range images {
-			imageRef, err := ParseImage(image)
-			if err != nil {
-				return result, err
+	err := store.read(func() error {
+		for id, images := range store.Images {
+			for _, image := range images {
+				imageRef, err := ParseImage(image)
+				if err != nil {
+					return err
+				}
+				result = append(result, Association{
+					ID:  id,
+					Ref: imageRef,
+				})
 			}
-			result = append(result, Association{
-				ID:  id,
-				Ref: imageRef,
-			})
 		}
+		return nil
+	})
+	return result, err
+}
+
+// GetAnnotation Get an annotation for an id, or an empty string if it isn't set.
+func (store *store) GetAnnotation(id string, key string) (string, error) {
+	if len(id) == 0 {
+		return "", fmt.Errorf("id required")
 	}
-	return result, nil
+	result := ""
+	err := store.read(func() error {
+		result = store.Annotations[id][key]
+		return nil
+	})
+	return result, err
+}
+
+// SetAnnotation Set an annotation for an id. An empty value removes the annotation.
+func (store *store) SetAnnotation(id string, key string, value string) error {
+	if len(id) == 0 {
+		return fmt.Errorf("id required")
+	}
+	if len(key) == 0 {
+		return fmt.Errorf("key required")
+	}
+
+	return store.update(func() (bool, error) {
+		annotations, exists := store.Annotations[id]
+		if !exists || annotations == nil {
+			annotations = make(map[string]string)
+		}
+
+		if len(value) == 0 {
+			delete(annotations, key)
+		} else {
+			annotations[key] = value
+		}
+
+		if len(annotations) == 0 {
+			delete(store.Annotations, id)
+		} else {
+			store.Annotations[id] = annotations
+		}
+
+		return true, nil
+	})
+}
+
+// DeleteAnnotations Remove all the annotations for an id.
+func (store *store) DeleteAnnotations(id string) error {
+	return store.update(func() (bool, error) {
+		if _, exists := store.Annotations[id]; !exists {
+			return false, nil
+		}
+		delete(store.Annotations, id)
+		return true, nil
+	})
+}
+
+// AnnotatedIDs Get every id that has annotations.
+func (store *store) AnnotatedIDs() []string {
+	result := []string{}
+	// The interface has no error. If the json file can't be read, there is nothing to report.
+	store.read(func() error {
+		for id := range store.Annotations {
+			result = append(result, id)
+		}
+		return nil
+	})
+	return result
+}
+
+// GetMetadata Get the metadata for an id. Nothing is set for ids that have no metadata.
+func (store *store) GetMetadata(id string) (ImageMetadata, error) {
+	if len(id) == 0 {
+		return ImageMetadata{}, fmt.Errorf("id required")
+	}
+	result := ImageMetadata{}
+	err := store.read(func() error {
+		result = store.Metadata[id]
+		return nil
+	})
+	return result, err
+}
+
+// UpdateMetadata Change the metadata for an id.
+func (store *store) UpdateMetadata(id string, update func(metadata *ImageMetadata)) error {
+	if len(id) == 0 {
+		return fmt.Errorf("id required")
+	}
+	return store.update(func() (bool, error) {
+		metadata := store.Metadata[id]
+		update(&metadata)
+		store.Metadata[id] = metadata
+		return true, nil
+	})
+}
+
+// DeleteMetadata Remove the metadata for an id.
+func (store *store) DeleteMetadata(id string) error {
+	return store.update(func() (bool, error) {
+		if _, exists := store.Metadata[id]; !exists {
+			return false, nil
+		}
+		delete(store.Metadata, id)
+		return true, nil
+	})
+}
+
+// MetadataIDs Get every id that has metadata.
+func (store *store) MetadataIDs() []string {
+	result := []string{}
+	// The interface has no error. If the json file can't be read, there is nothing to report.
+	store.read(func() error {
+		for id := range store.Metadata {
+			result = append(result, id)
+		}
+		return nil
+	})
+	return result
 }
 
 func (store *store) save() error {
@@ -198,12 +445,60 @@ func (store *store) save() error {
 	return ioutils.AtomicWriteFile(store.jsonPath, jsonData, 0600)
 }
 
+// reload Replaces the state of the store with the json file. A missing file is an empty store.
 func (store *store) reload() error {
+	store.Images = make(map[string][]string)
+	store.Annotations = make(map[string]map[string]string)
+	store.Metadata = make(map[string]ImageMetadata)
+	store.Version = storeVersion
+	store.migrated = false
+
 	f, err := os.Open(store.jsonPath)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
 		return err
 	}
 	defer f.Close()
 
-	return json.NewDecoder(f).Decode(&store)
+	// Files without a version are version 1.
+	store.Version = 1
+	err = json.NewDecoder(f).Decode(&store)
+	if err != nil {
+		return err
+	}
+
+	// Stores written before annotations existed don't have them.
+	if store.Annotations == nil {
+		store.Annotations = make(map[string]map[string]string)
+	}
+	if store.Images == nil {
+		store.Images = make(map[string][]string)
+	}
+	if store.Metadata == nil {
+		store.Metadata = make(map[string]ImageMetadata)
+	}
+
+	return store.migrate()
+}
+
+// migrate Upgrades the schema of what was loaded to storeVersion.
+func (store *store) migrate() error {
+	if store.Version > storeVersion {
+		return fmt.Errorf("%s was written by a newer version of darch (version %d, expected %d or older)", store.jsonPath, store.Version, storeVersion)
+	}
+
+	if store.Version < 2 {
+		// Every stage gets metadata, even though nothing was recorded about it.
+		for id := range store.Images {
+			if _, ok := store.Metadata[id]; !ok {
+				store.Metadata[id] = ImageMetadata{}
+			}
+		}
+		store.Version = 2
+		store.migrated = true
+	}
+
+	return nil
 }
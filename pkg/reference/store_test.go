@@ -1,18 +1,33 @@
 package reference
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
+	"sync"
 	"testing"
 
 	"github.com/godarch/darch/pkg/utils"
 )
 
+// createTestStoreDir Creates a directory for a store, and the lock file next to it.
+func createTestStoreDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "darch-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
 func TestCreatesOnNew(t *testing.T) {
 	t.Parallel()
 
-	jsonFile := path.Join(os.TempDir(), utils.NewID())
-	defer os.RemoveAll(jsonFile)
+	dir := createTestStoreDir(t)
+	defer os.RemoveAll(dir)
+	jsonFile := path.Join(dir, "images.json")
 
 	_, err := NewReferenceStore(jsonFile)
 	if err != nil {
@@ -25,8 +40,9 @@ func TestCreatesOnNew(t *testing.T) {
 }
 
 func TestTag(t *testing.T) {
-	jsonFile := path.Join(os.TempDir(), utils.NewID())
-	defer os.RemoveAll(jsonFile)
+	dir := createTestStoreDir(t)
+	defer os.RemoveAll(dir)
+	jsonFile := path.Join(dir, "images.json")
 
 	store, err := NewReferenceStore(jsonFile)
 	if err != nil {
@@ -52,8 +68,9 @@ func TestTag(t *testing.T) {
 }
 
 func TestTagTwiceSameId(t *testing.T) {
-	jsonFile := path.Join(os.TempDir(), utils.NewID())
-	defer os.RemoveAll(jsonFile)
+	dir := createTestStoreDir(t)
+	defer os.RemoveAll(dir)
+	jsonFile := path.Join(dir, "images.json")
 
 	store, err := NewReferenceStore(jsonFile)
 	if err != nil {
@@ -75,8 +92,9 @@ func TestTagTwiceSameId(t *testing.T) {
 }
 
 func TestTagTwiceDifferentIdError(t *testing.T) {
-	jsonFile := path.Join(os.TempDir(), utils.NewID())
-	defer os.RemoveAll(jsonFile)
+	dir := createTestStoreDir(t)
+	defer os.RemoveAll(dir)
+	jsonFile := path.Join(dir, "images.json")
 
 	store, err := NewReferenceStore(jsonFile)
 	if err != nil {
@@ -99,8 +117,9 @@ func TestTagTwiceDifferentIdError(t *testing.T) {
 }
 
 func TestTagTwiceDifferentIdForce(t *testing.T) {
-	jsonFile := path.Join(os.TempDir(), utils.NewID())
-	defer os.RemoveAll(jsonFile)
+	dir := createTestStoreDir(t)
+	defer os.RemoveAll(dir)
+	jsonFile := path.Join(dir, "images.json")
 
 	store, err := NewReferenceStore(jsonFile)
 	if err != nil {
@@ -123,8 +142,9 @@ func TestTagTwiceDifferentIdForce(t *testing.T) {
 }
 
 func TestErrorReturnDeleteNonExistingImage(t *testing.T) {
-	jsonFile := path.Join(os.TempDir(), utils.NewID())
-	defer os.RemoveAll(jsonFile)
+	dir := createTestStoreDir(t)
+	defer os.RemoveAll(dir)
+	jsonFile := path.Join(dir, "images.json")
 
 	store, err := NewReferenceStore(jsonFile)
 	if err != nil {
@@ -143,8 +163,9 @@ func TestErrorReturnDeleteNonExistingImage(t *testing.T) {
 }
 
 func TestGetImagesForId(t *testing.T) {
-	jsonFile := path.Join(os.TempDir(), utils.NewID())
-	defer os.RemoveAll(jsonFile)
+	dir := createTestStoreDir(t)
+	defer os.RemoveAll(dir)
+	jsonFile := path.Join(dir, "images.json")
 
 	store, err := NewReferenceStore(jsonFile)
 	if err != nil {
@@ -175,3 +196,321 @@ func TestGetImagesForId(t *testing.T) {
 		t.Fatalf("invalid image")
 	}
 }
+
+func TestAnnotations(t *testing.T) {
+	dir := createTestStoreDir(t)
+	defer os.RemoveAll(dir)
+	jsonFile := path.Join(dir, "images.json")
+
+	store, err := NewReferenceStore(jsonFile)
+	if err != nil {
+		t.Fatalf("error creating store %v", err)
+	}
+
+	id := utils.NewID()
+	err = store.SetAnnotation(id, "kernel-params", "quiet")
+	if err != nil {
+		t.Fatalf("error setting annotation %v", err)
+	}
+
+	// Make sure it survives a reload.
+	store, err = NewReferenceStore(jsonFile)
+	if err != nil {
+		t.Fatalf("error loading store %v", err)
+	}
+
+	value, err := store.GetAnnotation(id, "kernel-params")
+	if err != nil {
+		t.Fatalf("error getting annotation %v", err)
+	}
+	if value != "quiet" {
+		t.Fatalf("invalid annotation %s", value)
+	}
+	if ids := store.AnnotatedIDs(); len(ids) != 1 || ids[0] != id {
+		t.Fatalf("invalid annotated ids %v", ids)
+	}
+
+	err = store.SetAnnotation(id, "kernel-params", "")
+	if err != nil {
+		t.Fatalf("error removing annotation %v", err)
+	}
+	if ids := store.AnnotatedIDs(); len(ids) != 0 {
+		t.Fatalf("annotation not removed %v", ids)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	dir := createTestStoreDir(t)
+	defer os.RemoveAll(dir)
+	jsonFile := path.Join(dir, "images.json")
+
+	store, err := NewReferenceStore(jsonFile)
+	if err != nil {
+		t.Fatalf("error creating store %v", err)
+	}
+
+	err = store.UpdateMetadata("id1", func(metadata *ImageMetadata) {
+		metadata.SourceDigest = "sha256:abc"
+		metadata.Notes = "known good"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.UpdateMetadata("id1", func(metadata *ImageMetadata) {
+		metadata.Pinned = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another store sees the changes.
+	other, err := NewReferenceStore(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := other.GetMetadata("id1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.SourceDigest != "sha256:abc" || metadata.Notes != "known good" || !metadata.Pinned {
+		t.Fatalf("unexpected metadata %v", metadata)
+	}
+
+	err = other.DeleteMetadata("id1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := store.MetadataIDs(); len(ids) != 0 {
+		t.Fatalf("expected no metadata, got %v", ids)
+	}
+}
+
+func TestMigrateVersion1(t *testing.T) {
+	dir := createTestStoreDir(t)
+	defer os.RemoveAll(dir)
+	jsonFile := path.Join(dir, "images.json")
+
+	// A store written before the schema was versioned.
+	err := ioutil.WriteFile(jsonFile, []byte(`{"Images":{"id1":["base:latest"]},"Annotations":{"id1":{"kernel-params":"quiet"}}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewReferenceStore(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := store.MetadataIDs(); len(ids) != 1 || ids[0] != "id1" {
+		t.Fatalf("expected metadata for the existing stage, got %v", ids)
+	}
+	if params, _ := store.GetAnnotation("id1", "kernel-params"); params != "quiet" {
+		t.Fatalf("expected the annotations to be kept, got %s", params)
+	}
+
+	jsonData, err := ioutil.ReadFile(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]interface{}{}
+	if err = json.Unmarshal(jsonData, &values); err != nil {
+		t.Fatal(err)
+	}
+	if values["Version"] != float64(storeVersion) {
+		t.Fatalf("expected the migrated store to be saved, got %s", jsonData)
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	dir := createTestStoreDir(t)
+	defer os.RemoveAll(dir)
+	jsonFile := path.Join(dir, "images.json")
+
+	err := ioutil.WriteFile(jsonFile, []byte(fmt.Sprintf(`{"Version":%d,"Images":{}}`, storeVersion+1)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = NewReferenceStore(jsonFile); err == nil {
+		t.Fatal("expected an error for a store written by a newer version")
+	}
+}
+
+func TestConcurrentStores(t *testing.T) {
+	dir := createTestStoreDir(t)
+	defer os.RemoveAll(dir)
+	jsonFile := path.Join(dir, "images.json")
+
+	// Separate stores for the same file, like separate processes would have.
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			store, err := NewReferenceStore(jsonFile)
+			if err != nil {
+				errs <- err
+				return
+			}
+			image, _ := ParseImage(fmt.Sprintf("image%d:latest", i))
+			errs <- store.AddTag(image, fmt.Sprintf("id%d", i), false)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err := NewReferenceStore(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, err := store.AllImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 20 {
+		t.Fatalf("expected 20 images, got %d", len(images))
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	dir := createTestStoreDir(t)
+	defer os.RemoveAll(dir)
+	jsonFile := path.Join(dir, "images.json")
+
+	// Both stores are loaded before either writes, so each has a stale copy of the other's changes.
+	stores := []Store{}
+	for i := 0; i < 2; i++ {
+		store, err := NewReferenceStore(jsonFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores = append(stores, store)
+	}
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, len(stores))
+	for i, store := range stores {
+		wg.Add(1)
+		go func(i int, store Store) {
+			defer wg.Done()
+			<-start
+			for j := 0; j < 50; j++ {
+				err := store.UpdateMetadata("shared", func(metadata *ImageMetadata) {
+					metadata.Notes += fmt.Sprint(i)
+				})
+				if err == nil {
+					err = store.SetAnnotation(fmt.Sprintf("id%d", i), fmt.Sprintf("key%d", j), "value")
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}(i, store)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err := NewReferenceStore(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := store.GetMetadata("shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata.Notes) != 100 {
+		t.Fatalf("expected 100 updates, got %d (%s)", len(metadata.Notes), metadata.Notes)
+	}
+	for i := range stores {
+		for j := 0; j < 50; j++ {
+			value, err := store.GetAnnotation(fmt.Sprintf("id%d", i), fmt.Sprintf("key%d", j))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != "value" {
+				t.Fatalf("lost annotation key%d of id%d", j, i)
+			}
+		}
+	}
+}
+
+// TestStoreHelperProcess Isn't a real test, it's ran by TestMultipleProcesses as a separate process.
+func TestStoreHelperProcess(t *testing.T) {
+	jsonFile := os.Getenv("DARCH_TEST_STORE_FILE")
+	if len(jsonFile) == 0 {
+		return
+	}
+
+	store, err := NewReferenceStore(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	process := os.Getenv("DARCH_TEST_STORE_PROCESS")
+	for i := 0; i < 10; i++ {
+		image, _ := ParseImage(fmt.Sprintf("image-%s-%d:latest", process, i))
+		if err = store.AddTag(image, fmt.Sprintf("id-%s-%d", process, i), false); err != nil {
+			t.Fatal(err)
+		}
+		// Every process removes half of what it added.
+		if i%2 == 0 {
+			if _, err = store.Delete(image); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestMultipleProcesses(t *testing.T) {
+	dir := createTestStoreDir(t)
+	defer os.RemoveAll(dir)
+	jsonFile := path.Join(dir, "images.json")
+
+	processes := []*exec.Cmd{}
+	for i := 0; i < 5; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestStoreHelperProcess$")
+		cmd.Env = append(os.Environ(),
+			"DARCH_TEST_STORE_FILE="+jsonFile,
+			fmt.Sprintf("DARCH_TEST_STORE_PROCESS=%d", i))
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		processes = append(processes, cmd)
+	}
+	for _, cmd := range processes {
+		if err := cmd.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err := NewReferenceStore(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, err := store.AllImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 25 {
+		t.Fatalf("expected 25 images, got %d", len(images))
+	}
+	for _, image := range images {
+		var process, i int
+		if _, err = fmt.Sscanf(image.ID, "id-%d-%d", &process, &i); err != nil || i%2 == 0 {
+			t.Fatalf("unexpected image %s", image.ID)
+		}
+	}
+}
@@ -185,7 +185,7 @@ func (session *Session) createImageFromSnapshot(ctx context.Context, img contain
 	}
 
 	if m.Descriptor().MediaType == images.MediaTypeDockerSchema2ManifestList {
-		m, err = manifest.LoadManifestFromList(ctx, image_to.Target(), contentStore, runtime.GOOS, runtime.GOARCH)
+		m, err = manifest.LoadManifestFromList(ctx, img.Target(), session.content, runtime.GOOS, runtime.GOARCH)
 		if err != nil {
 			return err
 		}
@@ -2,25 +2,66 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/mount"
 	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/oci"
 	"github.com/godarch/darch/pkg/reference"
 	"github.com/godarch/darch/pkg/utils"
 	"github.com/godarch/darch/pkg/workspace"
+	"github.com/opencontainers/image-spec/identity"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 )
 
+// ExtractMode How an image is extracted.
+type ExtractMode string
+
+const (
+	// ExtractModeAuto Run the /darch-extract script of the image if it has one, since it can add kernel parameters to image.json.
+	// Images without the script, or that opt into native extraction with ExtractLabel, are extracted natively.
+	ExtractModeAuto ExtractMode = "auto"
+	// ExtractModeNative Mount the image read-only and build the rootfs with mksquashfs on the host.
+	ExtractModeNative ExtractMode = "native"
+	// ExtractModeContainer Run /darch-extract inside of a container from the image.
+	ExtractModeContainer ExtractMode = "container"
+)
+
+const (
+	// ExtractLabel The label an image sets to "native" when its /darch-extract script produces nothing extracting natively doesn't.
+	ExtractLabel         = "darch.extract"
+	extractScript        = "/darch-extract"
+	extractedRootFS      = "rootfs.squash"
+	extractedImageConfig = "image.json"
+)
+
+// ParseExtractMode Parses an extract mode given by a user.
+func ParseExtractMode(value string) (ExtractMode, error) {
+	switch mode := ExtractMode(value); mode {
+	case ExtractModeAuto, ExtractModeNative, ExtractModeContainer:
+		return mode, nil
+	case "":
+		return ExtractModeAuto, nil
+	default:
+		return "", fmt.Errorf("invalid extract mode %s", value)
+	}
+}
+
 // ExtractImage Extracts an image (with tag) to a specified directory
-func (session *Session) ExtractImage(ctx context.Context, imageRef reference.ImageRef, destination string) error {
+func (session *Session) ExtractImage(ctx context.Context, imageRef reference.ImageRef, destination string, mode ExtractMode) error {
 	ctx = namespaces.WithNamespace(ctx, "darch")
 
 	ctx, done, err := session.client.WithLease(ctx) // Prevent garbage collection while we work.
@@ -34,6 +75,176 @@ func (session *Session) ExtractImage(ctx context.Context, imageRef reference.Ima
 		return err
 	}
 
+	labels, err := session.getImageLabels(ctx, img)
+	if err != nil {
+		return err
+	}
+
+	useContainer := mode == ExtractModeContainer
+	if !useContainer {
+		err = session.withReadOnlyRootFS(ctx, img, func(root string) error {
+			hasExtractScript := utils.FileExists(path.Join(root, extractScript))
+			if selectExtractMode(mode, labels, hasExtractScript) == ExtractModeContainer {
+				useContainer = true
+				return nil
+			}
+			return extractRootFS(root, destination)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if useContainer {
+		err = session.extractWithContainer(ctx, img, destination)
+		if err != nil {
+			return err
+		}
+	}
+
+	return recordImageSource(destination, img.Target().Digest.String(), labels)
+}
+
+// selectExtractMode Get how an image is extracted, given the mode requested, the labels of the image and if it has a /darch-extract script.
+func selectExtractMode(mode ExtractMode, labels map[string]string, hasExtractScript bool) ExtractMode {
+	if mode != ExtractModeAuto {
+		return mode
+	}
+	if hasExtractScript && labels[ExtractLabel] != string(ExtractModeNative) {
+		return ExtractModeContainer
+	}
+	return ExtractModeNative
+}
+
+// getImageLabels Get the labels from the configuration of an image.
+func (session *Session) getImageLabels(ctx context.Context, img containerd.Image) (map[string]string, error) {
+	desc, err := img.Config(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := content.ReadBlob(ctx, session.content, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	var config ocispec.Image
+	if err = json.Unmarshal(p, &config); err != nil {
+		return nil, err
+	}
+
+	return config.Config.Labels, nil
+}
+
+// recordImageSource Adds the digest and labels of the image, and when it was extracted, to the extracted image.json.
+// The labels are available to hooks, and the digest is recorded for the stage when it is uploaded.
+// The extraction time is the creation time of the stage, since image.json is rewritten when it is uploaded.
+func recordImageSource(destination string, digest string, labels map[string]string) error {
+	imageConfig := path.Join(destination, extractedImageConfig)
+	jsonData, err := ioutil.ReadFile(imageConfig)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]interface{}{}
+	if err = json.Unmarshal(jsonData, &values); err != nil {
+		return err
+	}
+	values["sourcedigest"] = digest
+	values["createdat"] = time.Now().UTC()
+	if len(labels) > 0 {
+		values["labels"] = labels
+	}
+
+	jsonData, err = json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(imageConfig, jsonData, 0644)
+}
+
+// withReadOnlyRootFS Mounts a read-only view of the image's root filesystem for the duration of the callback.
+func (session *Session) withReadOnlyRootFS(ctx context.Context, img containerd.Image, f func(root string) error) error {
+	diffIDs, err := img.RootFS(ctx)
+	if err != nil {
+		return err
+	}
+
+	viewKey := utils.NewID()
+	mounts, err := session.snapshotter.View(ctx, viewKey, identity.ChainID(diffIDs).String())
+	if err != nil {
+		return err
+	}
+	defer session.snapshotter.Remove(ctx, viewKey)
+
+	return mount.WithTempMount(ctx, mounts, f)
+}
+
+// extractRootFS Builds the rootfs, kernel, initramfs and image.json from a mounted image.
+func extractRootFS(root string, destination string) error {
+	kernel, err := findBootFile(root, []string{"vmlinuz-*"})
+	if err != nil {
+		return err
+	}
+
+	initramfs, err := findBootFile(root, []string{"initramfs-*.img", "initrd.img-*"})
+	if err != nil {
+		return err
+	}
+
+	err = utils.CopyFile(path.Join(root, "boot", kernel), path.Join(destination, kernel))
+	if err != nil {
+		return err
+	}
+
+	err = utils.CopyFile(path.Join(root, "boot", initramfs), path.Join(destination, initramfs))
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("mksquashfs", root, path.Join(destination, extractedRootFS), "-noappend", "-no-progress")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("error running mksquashfs: %v", err)
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"kernel":    kernel,
+		"initramfs": initramfs,
+		"rootfs":    extractedRootFS,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path.Join(destination, extractedImageConfig), jsonData, 0644)
+}
+
+// findBootFile Finds the first file in /boot matching one of the patterns.
+// Fallback images (mkinitcpio's *-fallback.img) are ignored.
+func findBootFile(root string, patterns []string) (string, error) {
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(path.Join(root, "boot", pattern))
+		if err != nil {
+			return "", err
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			name := path.Base(match)
+			if strings.Contains(name, "-fallback") || !utils.FileExists(match) {
+				continue
+			}
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no file in /boot matching %s", strings.Join(patterns, ", "))
+}
+
+// extractWithContainer Runs /darch-extract inside of the image, and copies out the results.
+func (session *Session) extractWithContainer(ctx context.Context, img containerd.Image, destination string) error {
 	tempMountsWs, err := workspace.NewWorkspace("")
 	if err != nil {
 		return err
@@ -60,7 +271,7 @@ func (session *Session) ExtractImage(ctx context.Context, imageRef reference.Ima
 				oci.WithImageConfig(img),
 				oci.WithHostNamespace(specs.NetworkNamespace),
 				oci.WithMounts(mounts),
-				oci.WithProcessArgs("/usr/bin/env", "bash", "-c", "/darch-extract"),
+				oci.WithProcessArgs("/usr/bin/env", "bash", "-c", extractScript),
 			),
 		},
 	})
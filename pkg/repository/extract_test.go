@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSelectExtractMode(t *testing.T) {
+	tests := []struct {
+		mode             ExtractMode
+		labels           map[string]string
+		hasExtractScript bool
+		expected         ExtractMode
+	}{
+		// The script of the image is ran, since it can add kernel parameters.
+		{ExtractModeAuto, nil, true, ExtractModeContainer},
+		{ExtractModeAuto, map[string]string{"other": "native"}, true, ExtractModeContainer},
+		{ExtractModeAuto, map[string]string{ExtractLabel: "container"}, true, ExtractModeContainer},
+		// Unless the image opts into native extraction, or has no script to run.
+		{ExtractModeAuto, map[string]string{ExtractLabel: "native"}, true, ExtractModeNative},
+		{ExtractModeAuto, nil, false, ExtractModeNative},
+		{ExtractModeAuto, map[string]string{ExtractLabel: "container"}, false, ExtractModeNative},
+		// Modes given by the user are always used.
+		{ExtractModeNative, nil, true, ExtractModeNative},
+		{ExtractModeContainer, map[string]string{ExtractLabel: "native"}, false, ExtractModeContainer},
+	}
+	for _, test := range tests {
+		if result := selectExtractMode(test.mode, test.labels, test.hasExtractScript); result != test.expected {
+			t.Fatalf("expected %s for %s with %v (script: %t), got %s", test.expected, test.mode, test.labels, test.hasExtractScript, result)
+		}
+	}
+}
+
+func TestParseExtractMode(t *testing.T) {
+	if mode, err := ParseExtractMode(""); err != nil || mode != ExtractModeAuto {
+		t.Fatalf("expected auto by default, got %s %v", mode, err)
+	}
+	if _, err := ParseExtractMode("invalid"); err == nil {
+		t.Fatal("expected an error for an invalid mode")
+	}
+}
+
+func TestFindBootFile(t *testing.T) {
+	root, err := ioutil.TempDir("", "darch-extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if err = os.MkdirAll(path.Join(root, "boot", "vmlinuz-dir"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"vmlinuz-linux", "vmlinuz-linux-lts", "initramfs-linux-fallback.img", "initramfs-linux.img", "initrd.img-4.19.0"} {
+		if err = ioutil.WriteFile(path.Join(root, "boot", file), []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		patterns []string
+		expected string
+	}{
+		// Directories are skipped, and matches are sorted.
+		{[]string{"vmlinuz-*"}, "vmlinuz-linux"},
+		// Fallback images are skipped.
+		{[]string{"initramfs-*.img", "initrd.img-*"}, "initramfs-linux.img"},
+		// Later patterns are used when earlier ones don't match.
+		{[]string{"missing-*", "initrd.img-*"}, "initrd.img-4.19.0"},
+	}
+	for _, test := range tests {
+		result, err := findBootFile(root, test.patterns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != test.expected {
+			t.Fatalf("expected %s for %v, got %s", test.expected, test.patterns, result)
+		}
+	}
+
+	if _, err = findBootFile(root, []string{"missing-*"}); err == nil {
+		t.Fatal("expected an error when nothing matches")
+	}
+}
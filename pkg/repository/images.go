@@ -96,6 +96,20 @@ func (session *Session) TagImage(ctx context.Context, source, destination refere
 	return nil
 }
 
+// HasImage Returns true if the image exists locally.
+func (session *Session) HasImage(ctx context.Context, imageRef reference.ImageRef) (bool, error) {
+	ctx = namespaces.WithNamespace(ctx, "darch")
+
+	_, err := session.client.ImageService().Get(ctx, imageRef.FullName())
+	if err == nil {
+		return true, nil
+	}
+	if errdefs.IsNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
+
 // RemoveImage Removes an image locally.
 func (session *Session) RemoveImage(ctx context.Context, image string) error {
 	ctx = namespaces.WithNamespace(ctx, "darch")
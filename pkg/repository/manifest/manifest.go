@@ -5,6 +5,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"runtime"
+	"strings"
+
 	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/images"
 	digest "github.com/opencontainers/go-digest"
@@ -1,9 +1,12 @@
 package repository
 
 import (
+	"context"
+
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/snapshots"
 )
 
@@ -43,3 +46,9 @@ func NewSession(containerdSocket string) (*Session, error) {
 func (session *Session) Close() error {
 	return session.client.Close()
 }
+
+// WithLease Returns a context with a lease, which prevents garbage collection of anything
+// created with it (content, snapshots, etc) until the returned function is called.
+func (session *Session) WithLease(ctx context.Context) (context.Context, func(context.Context) error, error) {
+	return session.client.WithLease(namespaces.WithNamespace(ctx, "darch"))
+}
@@ -4,11 +4,14 @@ import (
 	"os"
 	"path"
 
+	"github.com/godarch/darch/pkg/reference"
 	"github.com/godarch/darch/pkg/utils"
 )
 
 // Clean goes through all the images in the live directory and deletes them
-// if there isn't a references in images.json.
+// if there isn't a references in images.json or previous.json.
+// Settings stored for stages that were deleted are removed as well.
+// Pinned stages are never deleted, even if nothing references them.
 func (session *Session) Clean() error {
 	liveImages, err := utils.GetChildDirectories(DefaultStagingDirectoryImages)
 	if err != nil {
@@ -16,12 +19,9 @@ func (session *Session) Clean() error {
 	}
 
 	// Let's get the ID of our current booted image, so we don't delete it.
-	currentBootID := ""
-	{
-		currentBootedImage, err := session.GetCurrentBootedImage()
-		if err != nil {
-			currentBootID = currentBootedImage.ID
-		}
+	currentBootID, err := getCurrentBootedStageID()
+	if err != nil && err != reference.ErrDoesNotExist {
+		return err
 	}
 
 	databaseImages, err := session.imageStore.AllImages()
@@ -29,20 +29,56 @@ func (session *Session) Clean() error {
 		return err
 	}
 
-	for _, liveImage := range liveImages {
-		found := false
+	previousImages, err := session.previousStore.AllImages()
+	if err != nil {
+		return err
+	}
+	databaseImages = append(databaseImages, previousImages...)
+
+	pinnedIDs, err := session.getPinnedIDs()
+	if err != nil {
+		return err
+	}
+	for pinnedID := range pinnedIDs {
+		// Nothing is left to protect for stages that were deleted by hand.
+		if !utils.DirectoryExists(path.Join(DefaultStagingDirectoryImages, pinnedID)) {
+			delete(pinnedIDs, pinnedID)
+		}
+	}
+
+	isStaged := func(id string) bool {
+		if id == currentBootID || pinnedIDs[id] {
+			return true
+		}
 		for _, databaseImage := range databaseImages {
-			if databaseImage.ID == liveImage {
-				found = true
+			if databaseImage.ID == id {
+				return true
 			}
 		}
-		if !found {
-			// It isn't in database, but are we currently booting it?
-			if liveImage == currentBootID {
-				found = true
+		return false
+	}
+
+	// Forget the settings and metadata of stages that no longer exist.
+	for _, annotatedID := range session.imageStore.AnnotatedIDs() {
+		if !isStaged(annotatedID) {
+			err = session.imageStore.DeleteAnnotations(annotatedID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, metadataID := range session.imageStore.MetadataIDs() {
+		if !isStaged(metadataID) {
+			err = session.imageStore.DeleteMetadata(metadataID)
+			if err != nil {
+				return err
 			}
 		}
-		if !found {
+	}
+
+	for _, liveImage := range liveImages {
+		// It may not be in the database, but are we currently booting it, or is it pinned?
+		if !isStaged(liveImage) {
 			err = os.RemoveAll(path.Join(DefaultStagingDirectoryImages, liveImage))
 			if err != nil {
 				return err
@@ -50,5 +86,21 @@ func (session *Session) Clean() error {
 		}
 	}
 
+	// Forget the hook runs of stages that no longer exist.
+	if utils.DirectoryExists(DefaultHookRunsDirectory) {
+		hookRunIDs, err := utils.GetChildDirectories(DefaultHookRunsDirectory)
+		if err != nil {
+			return err
+		}
+		for _, hookRunID := range hookRunIDs {
+			if !utils.DirectoryExists(path.Join(DefaultStagingDirectoryImages, hookRunID)) {
+				err = removeHookRuns(hookRunID)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
 	return nil
 }
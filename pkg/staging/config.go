@@ -0,0 +1,221 @@
+package staging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godarch/darch/pkg/utils"
+)
+
+var (
+	// DefaultStageConfigLocation Where the stage configuration lives.
+	DefaultStageConfigLocation = "/etc/darch/stage-config.json"
+)
+
+// Configuration The user configurable settings for the stage.
+type Configuration struct {
+	Retention RetentionConfiguration
+	Menu      MenuConfiguration
+	// KeepPrevious Keep the previous version of an image around when its tag is overwritten.
+	KeepPrevious bool
+	// VerifyOnSync Verify the digests of every image when updating the bootloader, warning when an image that failed is booted.
+	// This hashes every staged image on every sync, turn it off if that is too slow. "darch stage verify" does the same on demand.
+	VerifyOnSync bool
+	// Verity Generate a dm-verity hash tree for the rootfs of every uploaded image.
+	// This requires an initramfs that understands darch_verity_roothash.
+	Verity bool
+	// GrubMkconfigLib Use prepare_grub_to_access_device from grub-mkconfig_lib, instead of
+	// generating the grub commands to access the stage directory natively.
+	GrubMkconfigLib bool
+	// HookJobs How many images hooks are ran for at once, when running hooks for every image.
+	HookJobs int
+	// HookSandbox Run hook scripts in a sandbox.
+	HookSandbox HookSandboxConfiguration
+}
+
+// HookSandboxConfiguration If hook scripts are ran in a sandbox, where only their destination directory is writable.
+type HookSandboxConfiguration struct {
+	Enabled bool
+	// Timeout How long a hook can run in the sandbox before it is killed. Zero means forever.
+	Timeout time.Duration
+}
+
+// RetentionConfiguration The retention policy for the stage, and if it should be applied automatically.
+type RetentionConfiguration struct {
+	RetentionPolicy
+	ApplyOnUpload bool
+}
+
+// MenuConfiguration How the staged images are presented in the grub menu.
+type MenuConfiguration struct {
+	// GroupByName Put every tag of an image in a submenu for the image name, newest first.
+	GroupByName bool
+	// Recovery Add a recovery entry for every image, booted with RecoveryKernelParams.
+	Recovery             bool
+	RecoveryKernelParams string
+}
+
+type configurationJSON struct {
+	Retention       *retentionConfigurationJSON   `json:"retention"`
+	Menu            *menuConfigurationJSON        `json:"menu"`
+	KeepPrevious    *bool                         `json:"keep-previous"`
+	VerifyOnSync    *bool                         `json:"verify-on-sync"`
+	Verity          *bool                         `json:"verity"`
+	GrubMkconfigLib *bool                         `json:"grub-mkconfig-lib"`
+	HookJobs        *int                          `json:"hook-jobs"`
+	HookSandbox     *hookSandboxConfigurationJSON `json:"hook-sandbox"`
+}
+
+type hookSandboxConfigurationJSON struct {
+	Enabled *bool   `json:"enabled"`
+	Timeout *string `json:"timeout"`
+}
+
+type retentionConfigurationJSON struct {
+	KeepLast      *int    `json:"keep-last"`
+	PerName       *bool   `json:"per-name"`
+	OlderThan     *string `json:"older-than"`
+	ApplyOnUpload *bool   `json:"apply-on-upload"`
+}
+
+type menuConfigurationJSON struct {
+	GroupByName          *bool   `json:"group-by-name"`
+	Recovery             *bool   `json:"recovery"`
+	RecoveryKernelParams *string `json:"recovery-kernel-params"`
+}
+
+func buildDefaultConfiguration() Configuration {
+	return Configuration{
+		Retention: RetentionConfiguration{
+			RetentionPolicy: RetentionPolicy{
+				KeepLast:  0,
+				PerName:   false,
+				OlderThan: 0,
+			},
+			ApplyOnUpload: false,
+		},
+		Menu: MenuConfiguration{
+			GroupByName:          false,
+			Recovery:             false,
+			RecoveryKernelParams: "systemd.unit=rescue.target",
+		},
+		KeepPrevious:    true,
+		VerifyOnSync:    true,
+		Verity:          false,
+		GrubMkconfigLib: false,
+		HookJobs:        runtime.NumCPU(),
+		HookSandbox: HookSandboxConfiguration{
+			Enabled: false,
+			Timeout: 10 * time.Minute,
+		},
+	}
+}
+
+// LoadConfiguration Loads the stage configuration, using defaults for anything not configured.
+func LoadConfiguration() (Configuration, error) {
+	result := buildDefaultConfiguration()
+
+	if !utils.FileExists(DefaultStageConfigLocation) {
+		// No file exists, assume just the defaults.
+		return result, nil
+	}
+
+	jsonData, err := ioutil.ReadFile(DefaultStageConfigLocation)
+	if err != nil {
+		return result, err
+	}
+
+	jsonDeserialized := configurationJSON{}
+	err = json.Unmarshal(jsonData, &jsonDeserialized)
+	if err != nil {
+		return result, err
+	}
+
+	if retention := jsonDeserialized.Retention; retention != nil {
+		if retention.KeepLast != nil {
+			result.Retention.KeepLast = *retention.KeepLast
+		}
+		if retention.PerName != nil {
+			result.Retention.PerName = *retention.PerName
+		}
+		if retention.OlderThan != nil {
+			result.Retention.OlderThan, err = ParseAge(*retention.OlderThan)
+			if err != nil {
+				return result, err
+			}
+		}
+		if retention.ApplyOnUpload != nil {
+			result.Retention.ApplyOnUpload = *retention.ApplyOnUpload
+		}
+	}
+
+	if menu := jsonDeserialized.Menu; menu != nil {
+		if menu.GroupByName != nil {
+			result.Menu.GroupByName = *menu.GroupByName
+		}
+		if menu.Recovery != nil {
+			result.Menu.Recovery = *menu.Recovery
+		}
+		if menu.RecoveryKernelParams != nil {
+			result.Menu.RecoveryKernelParams = *menu.RecoveryKernelParams
+		}
+	}
+
+	if jsonDeserialized.KeepPrevious != nil {
+		result.KeepPrevious = *jsonDeserialized.KeepPrevious
+	}
+	if jsonDeserialized.VerifyOnSync != nil {
+		result.VerifyOnSync = *jsonDeserialized.VerifyOnSync
+	}
+	if jsonDeserialized.Verity != nil {
+		result.Verity = *jsonDeserialized.Verity
+	}
+	if jsonDeserialized.GrubMkconfigLib != nil {
+		result.GrubMkconfigLib = *jsonDeserialized.GrubMkconfigLib
+	}
+	if jsonDeserialized.HookJobs != nil {
+		result.HookJobs = *jsonDeserialized.HookJobs
+	}
+	if sandbox := jsonDeserialized.HookSandbox; sandbox != nil {
+		if sandbox.Enabled != nil {
+			result.HookSandbox.Enabled = *sandbox.Enabled
+		}
+		if sandbox.Timeout != nil {
+			result.HookSandbox.Timeout, err = time.ParseDuration(*sandbox.Timeout)
+			if err != nil {
+				return result, fmt.Errorf("invalid hook sandbox timeout %s: %v", *sandbox.Timeout, err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// ParseAge Parses an age, such as "30d" or "2w". Anything accepted by time.ParseDuration ("12h") is also valid.
+func ParseAge(value string) (time.Duration, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	multiplier := time.Duration(0)
+	switch {
+	case strings.HasSuffix(value, "d"):
+		multiplier = 24 * time.Hour
+	case strings.HasSuffix(value, "w"):
+		multiplier = 7 * 24 * time.Hour
+	default:
+		return time.ParseDuration(value)
+	}
+
+	count, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || count < 0 {
+		return 0, fmt.Errorf("invalid age %s", value)
+	}
+
+	return time.Duration(count) * multiplier, nil
+}
@@ -10,6 +10,8 @@ import (
 	"io"
 	"os"
 	"path"
+	"sort"
+	"strings"
 )
 
 var (
@@ -19,38 +21,58 @@ var (
 
 // PrintGrubMenuEntry Print the grub entry for the given staged image.
 func (session *Session) PrintGrubMenuEntry(stagedImage StagedImageNamed, output io.Writer) error {
-	device, err := block.GetBlockDeviceForPath(stagedImage.Dir)
-	if err != nil {
-		return err
-	}
-	relPathTodevice, err := block.GetPathRelativeToBlockDevice(stagedImage.Dir)
+	return session.printGrubMenuEntry(stagedImage, getMenuEntryTitle(stagedImage), "", "", output)
+}
+
+// printGrubMenuEntry Print a menu entry for the given staged image. If warning isn't empty, it is shown when the entry is booted.
+func (session *Session) printGrubMenuEntry(stagedImage StagedImageNamed, title string, extraKernelParams string, warning string, output io.Writer) error {
+	device, err := block.GetDeviceForPath(stagedImage.Dir)
 	if err != nil {
 		return err
 	}
-	uuid, err := block.GetUUIDForBlockDevice(device)
+	relPathTodevice := device.RelativePath
+	additionalParams, err := session.GetEffectiveKernelParams(stagedImage)
 	if err != nil {
 		return err
 	}
-	additionalParams := ""
-	if len(stagedImage.KernelParams) > 0 {
-		additionalParams = stagedImage.KernelParams + " "
+	if len(additionalParams) > 0 {
+		additionalParams = additionalParams + " "
 	}
-	commandLine := fmt.Sprintf("%sdarch_rootfs=%s darch_dir=UUID=%s:%s darch_stageid=%s darch_nodoublemount=%t",
+	commandLine := fmt.Sprintf("%sdarch_rootfs=%s %s darch_stageid=%s darch_nodoublemount=%t",
 		additionalParams,
 		stagedImage.RootFS,
-		uuid,
-		relPathTodevice,
+		getDeviceParams("darch_dir", device),
 		stagedImage.ID,
 		stagedImage.NoDoubleMount)
+	if verityParams := getVerityParams(stagedImage); len(verityParams) > 0 {
+		commandLine = fmt.Sprintf("%s %s", commandLine, verityParams)
+	}
+	if hasPersistedPaths(stagedImage) {
+		// The paths to persist are listed in the stage directory, and stored per image name.
+		persistDevice, err := block.GetDeviceForPath(getPersistDirectory(stagedImage.Ref))
+		if err != nil {
+			return err
+		}
+		commandLine = fmt.Sprintf("%s %s", commandLine, getDeviceParams("darch_persist", persistDevice))
+	}
+	if len(extraKernelParams) > 0 {
+		commandLine = fmt.Sprintf("%s %s", commandLine, extraKernelParams)
+	}
 
-	return grub.MenuEntry(fmt.Sprintf("Darch - %s", stagedImage.Ref.FullName()), func(w io.Writer) error {
-		err := grub.PrepareAccessToDevice(device, w, false)
+	return grub.MenuEntry(title, func(w io.Writer) error {
+		if len(warning) > 0 {
+			_, err := fmt.Fprintf(w, "echo 'WARNING: %s'\nsleep 5\n", strings.Replace(warning, "'", "", -1))
+			if err != nil {
+				return err
+			}
+		}
+		err := session.prepareAccessToDevice(device, w)
 		if err != nil {
 			return err
 		}
-		err = grub.LoadLinux(path.Join(relPathTodevice, stagedImage.Kernel),
+		err = grub.LoadLinux(grub.PathOnDevice(device, path.Join(relPathTodevice, stagedImage.Kernel)),
 			commandLine,
-			path.Join(relPathTodevice, stagedImage.InitRAMFS),
+			grub.PathOnDevice(device, path.Join(relPathTodevice, stagedImage.InitRAMFS)),
 			w)
 		if err != nil {
 			return err
@@ -59,7 +81,69 @@ func (session *Session) PrintGrubMenuEntry(stagedImage StagedImageNamed, output
 	}, output)
 }
 
+// prepareAccessToDevice Writes the grub commands to access the device,
+// using grub-mkconfig_lib instead of generating them natively if configured to.
+func (session *Session) prepareAccessToDevice(device block.Device, w io.Writer) error {
+	if !session.config.GrubMkconfigLib || len(device.Path) == 0 {
+		return grub.PrepareAccessToBlockDevice(device, w, false)
+	}
+	encrypted, err := block.IsEncrypted(device.Path)
+	if err != nil {
+		return err
+	}
+	return grub.PrepareAccessToDevice(device.Path, w, encrypted)
+}
+
+// getDeviceParams Get the kernel parameter the initramfs uses to find a directory, such as the stage directory (darch_dir).
+// Block devices are found by UUID (darch_dir=UUID=<uuid>:<path>), ZFS datasets by name (darch_dir=ZFS=<dataset>:<path>).
+// When the path is only valid with certain mount options (btrfs subvolumes), they are given with <param>_options.
+func getDeviceParams(param string, device block.Device) string {
+	if device.FSType == "zfs" {
+		return fmt.Sprintf("%s=ZFS=%s:%s", param, device.Dataset, device.RelativePath)
+	}
+	result := fmt.Sprintf("%s=UUID=%s:%s", param, device.UUID, device.RelativePath)
+	if options := device.MountOptions(); len(options) > 0 {
+		result = fmt.Sprintf("%s %s_options=%s", result, param, options)
+	}
+	return result
+}
+
+// getMenuEntryTitle Get the title of the grub menu entry for the given staged image.
+func getMenuEntryTitle(stagedImage StagedImageNamed) string {
+	return fmt.Sprintf("Darch - %s", stagedImage.DisplayName())
+}
+
+// getSubmenuTitle Get the title of the grub submenu holding every tag of an image name.
+func getSubmenuTitle(name string) string {
+	return fmt.Sprintf("Darch - %s", name)
+}
+
+// groupStagedImagesByName Groups the images by their name (without the tag), with the newest image first.
+// The groups are sorted by name.
+func groupStagedImagesByName(images []StagedImageNamed) [][]StagedImageNamed {
+	groups := make(map[string][]StagedImageNamed)
+	names := make([]string, 0)
+	for _, image := range images {
+		name := image.Ref.Name()
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], image)
+	}
+	sort.Strings(names)
+
+	result := make([][]StagedImageNamed, 0, len(names))
+	for _, name := range names {
+		group := groups[name]
+		sort.Stable(sortStagedImageNamedByAgeDesc(group))
+		result = append(result, group)
+	}
+	return result
+}
+
 // SyncBootloader Updates the /etc/darch/grub.cfg to represent the current stage.
+// If configured, images whose artifacts don't match their recorded digests are marked as such,
+// the images are grouped in submenus by name, and recovery entries are added.
 func (session *Session) SyncBootloader() error {
 	allImages, err := session.GetAllStaged()
 	if err != nil {
@@ -69,10 +153,27 @@ func (session *Session) SyncBootloader() error {
 	var b bytes.Buffer
 	w := bufio.NewWriter(&b)
 
-	for _, image := range allImages {
-		err = session.PrintGrubMenuEntry(image, w)
-		if err != nil {
-			return err
+	if session.config.Menu.GroupByName {
+		for _, group := range groupStagedImagesByName(allImages) {
+			err = grub.Submenu(getSubmenuTitle(group[0].Ref.Name()), func(w io.Writer) error {
+				for _, image := range group {
+					err := session.printGrubMenuEntries(image, w)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			}, w)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		for _, image := range allImages {
+			err = session.printGrubMenuEntries(image, w)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
@@ -83,3 +184,28 @@ func (session *Session) SyncBootloader() error {
 
 	return ioutils.AtomicWriteFile(DefaultGrubConfigPath, b.Bytes(), os.ModePerm)
 }
+
+// printGrubMenuEntries Print the menu entry for an image, and its recovery entry if configured.
+// Images that fail verification keep their title, since grub finds the default entry by title,
+// and warn when they are booted instead.
+func (session *Session) printGrubMenuEntries(image StagedImageNamed, output io.Writer) error {
+	title := getMenuEntryTitle(image)
+	warning := ""
+	if session.config.VerifyOnSync {
+		err := session.VerifyImage(image)
+		if err != nil && err != ErrNoDigests {
+			warning = fmt.Sprintf("%s failed verification: %v", image.DisplayName(), err)
+		}
+	}
+
+	err := session.printGrubMenuEntry(image, title, "", warning, output)
+	if err != nil {
+		return err
+	}
+
+	if !session.config.Menu.Recovery {
+		return nil
+	}
+
+	return session.printGrubMenuEntry(image, title+" (recovery)", session.config.Menu.RecoveryKernelParams, warning, output)
+}
@@ -0,0 +1,61 @@
+package staging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/godarch/darch/pkg/block"
+)
+
+func TestDeviceParams(t *testing.T) {
+	tests := []struct {
+		device   block.Device
+		expected string
+	}{
+		{
+			block.Device{FSType: "ext4", UUID: "a85bf1c9-59a1-4dba-9df9-6fbbfa03466c", RelativePath: "/var/lib/darch/stage/live/id"},
+			"darch_dir=UUID=a85bf1c9-59a1-4dba-9df9-6fbbfa03466c:/var/lib/darch/stage/live/id",
+		},
+		{
+			block.Device{FSType: "btrfs", UUID: "a85bf1c9-59a1-4dba-9df9-6fbbfa03466c", Subvolume: "/@darch", RelativePath: "/@darch/stage/live/id"},
+			"darch_dir=UUID=a85bf1c9-59a1-4dba-9df9-6fbbfa03466c:/@darch/stage/live/id darch_dir_options=subvolid=5",
+		},
+		{
+			block.Device{FSType: "zfs", UUID: "0bd8dba3b2a6c6ad", Dataset: "rpool/darch", RelativePath: "/stage/live/id"},
+			"darch_dir=ZFS=rpool/darch:/stage/live/id",
+		},
+	}
+	for _, test := range tests {
+		result := getDeviceParams("darch_dir", test.device)
+		if result != test.expected {
+			t.Fatalf("expected %s, got %s", test.expected, result)
+		}
+	}
+}
+
+func TestGroupStagedImagesByName(t *testing.T) {
+	now := time.Now()
+	images := []StagedImageNamed{
+		buildStagedImage(t, "base:1", 3*time.Hour, now),
+		buildStagedImage(t, "desktop:1", 2*time.Hour, now),
+		buildStagedImage(t, "base:2", 1*time.Hour, now),
+		buildStagedImage(t, "base:latest", 0, now),
+	}
+
+	groups := groupStagedImagesByName(images)
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups, got %d", len(groups))
+	}
+
+	expected := [][]string{{"base:latest", "base:2", "base:1"}, {"desktop:1"}}
+	for i, group := range groups {
+		if len(group) != len(expected[i]) {
+			t.Fatalf("expected %d images in group %d, got %d", len(expected[i]), i, len(group))
+		}
+		for j, image := range group {
+			if image.ID != expected[i][j] {
+				t.Fatalf("expected %s at %d in group %d, got %s", expected[i][j], j, i, image.ID)
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package staging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/docker/docker/pkg/ioutils"
+	"github.com/godarch/darch/pkg/hooks"
+	"github.com/godarch/darch/pkg/utils"
+)
+
+const (
+	// hooksSnapshotFile The file in a stage directory describing the output of the hooks, as of the last install.
+	hooksSnapshotFile = "hooks.json"
+	// previousHooksSnapshotFile The snapshot of the install before that.
+	previousHooksSnapshotFile = "hooks.previous.json"
+)
+
+var hookOrderPrefix = regexp.MustCompile(`^\d{8}_`)
+
+// HookChange A difference in the output of a hook between the last two installs.
+type HookChange struct {
+	Hook string
+	// Path The path relative to the destination directory of the hook, or, if Injected, the path in the rootfs.
+	Path string
+	// Change Either "added", "removed" or "changed".
+	Change string
+	// Injected The change is to a file the manifest of the hook injects into the rootfs.
+	Injected bool
+}
+
+type hooksSnapshot struct {
+	Hooks map[string]hookSnapshot `json:"hooks"`
+}
+
+type hookSnapshot struct {
+	// Files The digest of every file in the destination directory, by relative path.
+	Files    map[string]string `json:"files"`
+	Manifest *hooks.Manifest   `json:"manifest,omitempty"`
+}
+
+// snapshotHooks Records the output of the installed hooks for a stage,
+// keeping the previous snapshot around so that it can be compared with.
+func snapshotHooks(id string) error {
+	stageDir := path.Join(DefaultStagingDirectoryImages, id)
+	hooksDir := path.Join(stageDir, "hooks")
+
+	snapshot := hooksSnapshot{
+		Hooks: make(map[string]hookSnapshot),
+	}
+
+	hookDirs, err := utils.GetChildDirectories(hooksDir)
+	if err != nil {
+		return err
+	}
+	for _, hookDir := range hookDirs {
+		destinationDirectory := path.Join(hooksDir, hookDir)
+		hook := hookSnapshot{
+			Files: make(map[string]string),
+		}
+		err = filepath.Walk(destinationDirectory, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			relativePath, err := filepath.Rel(destinationDirectory, file)
+			if err != nil {
+				return err
+			}
+			d, err := digestFile(file)
+			if err != nil {
+				return err
+			}
+			hook.Files[relativePath] = d.String()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		hook.Manifest, err = hooks.LoadManifest(destinationDirectory)
+		if err != nil {
+			return fmt.Errorf("hook %s: %v", hookDir, err)
+		}
+		snapshot.Hooks[hookOrderPrefix.ReplaceAllString(hookDir, "")] = hook
+	}
+
+	jsonData, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	currentFile := path.Join(stageDir, hooksSnapshotFile)
+	if utils.FileExists(currentFile) {
+		err = os.Rename(currentFile, path.Join(stageDir, previousHooksSnapshotFile))
+		if err != nil {
+			return err
+		}
+	}
+
+	return ioutils.AtomicWriteFile(currentFile, jsonData, 0644)
+}
+
+func loadHooksSnapshot(file string) (hooksSnapshot, error) {
+	result := hooksSnapshot{
+		Hooks: make(map[string]hookSnapshot),
+	}
+	jsonData, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return result, err
+	}
+	err = json.Unmarshal(jsonData, &result)
+	if result.Hooks == nil {
+		result.Hooks = make(map[string]hookSnapshot)
+	}
+	return result, err
+}
+
+// GetHookChanges Get what changed in the output of the hooks for an image, between the last two times they were installed.
+func (session *Session) GetHookChanges(image StagedImageNamed) ([]HookChange, error) {
+	current, err := loadHooksSnapshot(path.Join(image.Dir, hooksSnapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	previous, err := loadHooksSnapshot(path.Join(image.Dir, previousHooksSnapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	return diffHooksSnapshots(previous, current), nil
+}
+
+func diffHooksSnapshots(previous hooksSnapshot, current hooksSnapshot) []HookChange {
+	result := []HookChange{}
+
+	hookNames := []string{}
+	for name := range previous.Hooks {
+		hookNames = append(hookNames, name)
+	}
+	for name := range current.Hooks {
+		if _, ok := previous.Hooks[name]; !ok {
+			hookNames = append(hookNames, name)
+		}
+	}
+	sort.Strings(hookNames)
+
+	for _, name := range hookNames {
+		previousHook, currentHook := previous.Hooks[name], current.Hooks[name]
+		for _, change := range diffStringMaps(previousHook.Files, currentHook.Files) {
+			change.Hook = name
+			result = append(result, change)
+		}
+		for _, change := range diffStringMaps(getInjectedFiles(previousHook), getInjectedFiles(currentHook)) {
+			change.Hook = name
+			change.Injected = true
+			result = append(result, change)
+		}
+	}
+
+	return result
+}
+
+// getInjectedFiles Get the digest and mode of every file the hook injects into the rootfs, by destination.
+func getInjectedFiles(hook hookSnapshot) map[string]string {
+	result := make(map[string]string)
+	if hook.Manifest == nil {
+		return result
+	}
+	for _, file := range hook.Manifest.Files {
+		result[path.Clean(file.Destination)] = fmt.Sprintf("%s %s", hook.Files[path.Clean(file.Source)], file.Mode)
+	}
+	return result
+}
+
+func diffStringMaps(previous map[string]string, current map[string]string) []HookChange {
+	result := []HookChange{}
+	for key, value := range current {
+		previousValue, ok := previous[key]
+		if !ok {
+			result = append(result, HookChange{Path: key, Change: "added"})
+		} else if previousValue != value {
+			result = append(result, HookChange{Path: key, Change: "changed"})
+		}
+	}
+	for key := range previous {
+		if _, ok := current[key]; !ok {
+			result = append(result, HookChange{Path: key, Change: "removed"})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
@@ -0,0 +1,204 @@
+package staging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/pkg/ioutils"
+	"github.com/godarch/darch/pkg/hooks"
+	"github.com/godarch/darch/pkg/reference"
+	"github.com/godarch/darch/pkg/utils"
+)
+
+var (
+	// DefaultHookRunsDirectory Where the log of every time hooks were ran for a stage is kept, by stage id.
+	DefaultHookRunsDirectory = path.Join(DefaultStagingDirectory, "hook-runs")
+)
+
+const (
+	hookRunsFile = "runs.json"
+	// maxHookRuns How many runs are kept for every stage, along with their output.
+	maxHookRuns = 20
+)
+
+// HookRun A time the hooks were ran for an image.
+type HookRun struct {
+	Image     string        `json:"image"`
+	StartedAt time.Time     `json:"started-at"`
+	Duration  time.Duration `json:"duration"`
+	Succeeded bool          `json:"succeeded"`
+	// Installed The output of the hooks was installed into the stage, which only happens if they succeeded for every image being installed.
+	Installed bool `json:"installed"`
+	// Fingerprint The state of DefaultHooksPath after the run, to tell if the hooks changed since.
+	Fingerprint string `json:"fingerprint"`
+	// OutputPath The file with the output of every hook of the run.
+	OutputPath string         `json:"output-path"`
+	Hooks      []HookRunEntry `json:"hooks"`
+}
+
+// HookRunEntry A hook that was ran.
+type HookRunEntry struct {
+	Hook      string        `json:"hook"`
+	StartedAt time.Time     `json:"started-at"`
+	Duration  time.Duration `json:"duration"`
+	// ExitStatus The exit status of the hook script, or -1 if it didn't exit by itself.
+	ExitStatus int    `json:"exit-status"`
+	Error      string `json:"error,omitempty"`
+	OutputPath string `json:"output-path"`
+}
+
+// HooksStatus The last time the hooks were ran for an image, and if they need to be ran again.
+type HooksStatus struct {
+	// LastRun The last run for the image, nil if hooks were never ran for it.
+	LastRun *HookRun
+	// Stale The last run failed or wasn't installed, or the hooks changed since.
+	Stale bool
+}
+
+// newHookRun Starts recording a run of the hooks for an image, returning the file the output should be written to.
+func newHookRun(association reference.Association) (*HookRun, *os.File, error) {
+	runsDirectory := path.Join(DefaultHookRunsDirectory, association.ID)
+	err := os.MkdirAll(runsDirectory, 0700)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	run := &HookRun{
+		Image:     association.Ref.FullName(),
+		StartedAt: time.Now(),
+		Hooks:     []HookRunEntry{},
+	}
+	run.OutputPath = path.Join(runsDirectory, fmt.Sprintf("%s-%s.log", run.StartedAt.UTC().Format("20060102T150405Z"), utils.NewID()))
+
+	f, err := os.OpenFile(run.OutputPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return run, f, nil
+}
+
+func (run *HookRun) addHook(hook string, started time.Time, err error) {
+	run.Hooks = append(run.Hooks, HookRunEntry{
+		Hook:       hook,
+		StartedAt:  started,
+		Duration:   time.Since(started),
+		ExitStatus: getExitStatus(err),
+		Error:      getErrorMessage(err),
+		OutputPath: run.OutputPath,
+	})
+}
+
+func getExitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Exited() {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
+
+func getErrorMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// recordHookRun Adds a finished run to the log of the stage, removing the oldest runs (and their output).
+func (session *Session) recordHookRun(id string, run *HookRun) error {
+	fingerprint, err := hooks.GetHooksFingerprint()
+	if err != nil {
+		return err
+	}
+	run.Fingerprint = fingerprint
+
+	// Runs for a stage tagged with multiple names can finish at the same time.
+	session.hookRunsLock.Lock()
+	defer session.hookRunsLock.Unlock()
+
+	runs, err := loadHookRuns(id)
+	if err != nil {
+		return err
+	}
+	runs = append(runs, *run)
+	for len(runs) > maxHookRuns {
+		os.Remove(runs[0].OutputPath)
+		runs = runs[1:]
+	}
+
+	jsonData, err := json.MarshalIndent(runs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutils.AtomicWriteFile(path.Join(DefaultHookRunsDirectory, id, hookRunsFile), jsonData, 0600)
+}
+
+func loadHookRuns(id string) ([]HookRun, error) {
+	result := []HookRun{}
+	jsonData, err := ioutil.ReadFile(path.Join(DefaultHookRunsDirectory, id, hookRunsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(jsonData, &result)
+	return result, err
+}
+
+// GetHookRuns Get every recorded run of the hooks for an image, oldest first.
+func (session *Session) GetHookRuns(image StagedImageNamed) ([]HookRun, error) {
+	runs, err := loadHookRuns(image.ID)
+	if err != nil {
+		return nil, err
+	}
+	result := []HookRun{}
+	for _, run := range runs {
+		if run.Image == image.Ref.FullName() {
+			result = append(result, run)
+		}
+	}
+	return result, nil
+}
+
+// GetHooksStatus Get the last run of the hooks for an image, and if it is stale.
+func (session *Session) GetHooksStatus(image StagedImageNamed) (HooksStatus, error) {
+	result := HooksStatus{}
+
+	runs, err := session.GetHookRuns(image)
+	if err != nil {
+		return result, err
+	}
+
+	fingerprint, err := hooks.GetHooksFingerprint()
+	if err != nil {
+		return result, err
+	}
+
+	return getHooksStatus(runs, fingerprint), nil
+}
+
+func getHooksStatus(runs []HookRun, fingerprint string) HooksStatus {
+	if len(runs) == 0 {
+		return HooksStatus{Stale: true}
+	}
+	lastRun := runs[len(runs)-1]
+	return HooksStatus{
+		LastRun: &lastRun,
+		Stale:   !lastRun.Succeeded || !lastRun.Installed || lastRun.Fingerprint != fingerprint,
+	}
+}
+
+// removeHookRuns Removes the log of the hook runs for a stage.
+func removeHookRuns(id string) error {
+	return os.RemoveAll(path.Join(DefaultHookRunsDirectory, id))
+}
@@ -1,10 +1,17 @@
 package staging
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/godarch/darch/pkg/workspace"
 
@@ -13,8 +20,41 @@ import (
 	"github.com/godarch/darch/pkg/utils"
 )
 
-// RunAllHooks Run the hooks on every image.
-func (session *Session) RunAllHooks() error {
+// HookFailure A hook that failed for an image.
+type HookFailure struct {
+	Image string
+	// Hook The hook that failed, or empty if the failure wasn't caused by a single hook.
+	Hook string
+	Err  error
+}
+
+func (failure HookFailure) Error() string {
+	if len(failure.Hook) == 0 {
+		return fmt.Sprintf("running hooks for %s: %v", failure.Image, failure.Err)
+	}
+	return fmt.Sprintf("hook %s for %s: %v", failure.Hook, failure.Image, failure.Err)
+}
+
+// HooksFailedError Returned when running hooks failed for some of the images.
+type HooksFailedError struct {
+	Failures []HookFailure
+}
+
+func (err HooksFailedError) Error() string {
+	messages := []string{}
+	for _, failure := range err.Failures {
+		messages = append(messages, "\t"+failure.Error())
+	}
+	return fmt.Sprintf("hooks failed for %d image(s):\n%s", len(err.Failures), strings.Join(messages, "\n"))
+}
+
+// RunAllHooks Run the hooks on every image, running up to the given number of images at once.
+// If jobs isn't positive, the configured number of jobs is used.
+// The output of the hooks is printed once all the hooks for an image complete.
+// A failure for one image doesn't prevent the hooks from running on the others,
+// every failure is returned in a HooksFailedError.
+// The new hooks are only installed if they succeeded for every image, otherwise every image keeps its current hooks.
+func (session *Session) RunAllHooks(jobs int) error {
 	allAssoications, err := session.imageStore.AllImages()
 	if err != nil {
 		return err
@@ -23,13 +63,71 @@ func (session *Session) RunAllHooks() error {
 	if err != nil {
 		return err
 	}
-	for _, association := range allAssoications {
-		err = session.runHooksForAssociation(association, allHooks)
-		if err != nil {
-			return err
+
+	if jobs <= 0 {
+		jobs = session.config.HookJobs
+	}
+	if jobs <= 0 {
+		jobs = 1
+	}
+
+	var (
+		outputLock    sync.Mutex
+		failures      = make([]HookFailure, len(allAssoications))
+		installations = make([]*hookInstallation, len(allAssoications))
+		work          = make(chan int)
+		wg            sync.WaitGroup
+	)
+
+	for worker := 0; worker < jobs; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				association := allAssoications[i]
+
+				var output bytes.Buffer
+				installation, err := session.runHooksForAssociation(association, allHooks, nil, &output)
+				installations[i] = installation
+				if err != nil {
+					failure, ok := err.(HookFailure)
+					if !ok {
+						failure = HookFailure{Image: association.Ref.FullName(), Err: err}
+					}
+					failures[i] = failure
+				}
+
+				outputLock.Lock()
+				fmt.Printf("==> hooks for %s\n", association.Ref.FullName())
+				os.Stdout.Write(output.Bytes())
+				outputLock.Unlock()
+			}
+		}()
+	}
+
+	for i := range allAssoications {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	result := HooksFailedError{}
+	for _, failure := range failures {
+		if failure.Err != nil {
+			result.Failures = append(result.Failures, failure)
 		}
 	}
-	return nil
+	if len(result.Failures) > 0 {
+		for _, installation := range installations {
+			if installation != nil {
+				installation.discard()
+				session.recordInstallation(installation, false)
+			}
+		}
+		return result
+	}
+
+	return session.installHooks(installations)
 }
 
 // RunHooksForImage Run hooks for a single image.
@@ -42,72 +140,372 @@ func (session *Session) RunHooksForImage(imageRef reference.ImageRef) error {
 	if err != nil {
 		return err
 	}
-	return session.runHooksForAssociation(association, allHooks)
+	installation, err := session.runHooksForAssociation(association, allHooks, os.Stdin, os.Stdout)
+	if err != nil {
+		return err
+	}
+	return session.installHooks([]*hookInstallation{installation})
+}
+
+// hookInstallation The output of the hooks for a stage, waiting to be installed into the stage directory.
+type hookInstallation struct {
+	id string
+	ws workspace.Workspace
+	// backupDir Where the hooks that were replaced are kept, until every installation succeeds.
+	backupDir string
+	committed bool
+	// run The run that produced the hooks, recorded once it is known if they were installed.
+	run *HookRun
 }
 
-func (session *Session) runHooksForAssociation(association reference.Association, hs []hooks.Hook) error {
+// installHooks Installs the hooks for every stage, or none of them.
+// The hooks being replaced are kept until every installation, and everything recorded about it, succeeds, and restored if one fails.
+func (session *Session) installHooks(installations []*hookInstallation) error {
+	defer func() {
+		for _, installation := range installations {
+			installation.discard()
+		}
+	}()
 
-	ws, err := workspace.NewWorkspace(DefaultStagingDirectoryTmp)
+	var err error
+	for _, installation := range installations {
+		err = installation.commit()
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = session.recordInstalledHooks(installations)
+	}
 	if err != nil {
+		// Undo in the reverse order, since a stage tagged with multiple names is installed more than once.
+		for i := len(installations) - 1; i >= 0; i-- {
+			rollbackErr := installations[i].rollback()
+			if rollbackErr != nil {
+				return fmt.Errorf("%v (and couldn't restore the previous hooks: %v)", err, rollbackErr)
+			}
+		}
+		for _, installation := range installations {
+			session.recordInstallation(installation, false)
+		}
 		return err
 	}
-	defer ws.Destroy()
 
-	for _, hook := range hs {
+	for _, installation := range installations {
+		installation.finish()
+		session.recordInstallation(installation, true)
+	}
 
-		if !hooks.AppliesToImage(hook, association.Ref) {
+	return nil
+}
+
+// recordInstalledHooks Records what the committed hooks produced, and the paths to persist for their stages.
+func (session *Session) recordInstalledHooks(installations []*hookInstallation) error {
+	// Record what the hooks produced, so that it can be compared with the next install.
+	// A stage tagged with multiple names is installed more than once, the last installation wins.
+	snapshotted := make(map[string]bool)
+	for i := len(installations) - 1; i >= 0; i-- {
+		if snapshotted[installations[i].id] {
 			continue
 		}
-
-		var destinationHookDirectory = path.Join(ws.Path, "hooks", hook.NameWithOrder)
-
-		err = os.MkdirAll(destinationHookDirectory, os.ModePerm)
+		snapshotted[installations[i].id] = true
+		err := snapshotHooks(installations[i].id)
 		if err != nil {
 			return err
 		}
+	}
 
-		hookFile := path.Join(hook.Path, "hook")
-		if !utils.FileExists(hookFile) {
-			return fmt.Errorf("Hook script %s doesn't exist", hookFile)
-		}
-
-		err = utils.CopyFile(hookFile, path.Join(destinationHookDirectory, "hook"))
+	// The paths to persist are materialized like the output of a hook.
+	persistEntries, err := LoadPersistEntries()
+	if err != nil {
+		return err
+	}
+	for _, installation := range installations {
+		err = session.materializePersistedPaths(installation.id, persistEntries)
 		if err != nil {
 			return err
 		}
+	}
 
-		cmd := exec.Command("/bin/bash", "-c", ". "+path.Join(destinationHookDirectory, "hook")+" && install")
-		cmd.Env = append(os.Environ(), []string{
-			fmt.Sprintf("DARCH_HOOKS_DIR=%s", hook.HooksPath),
-			fmt.Sprintf("DARCH_HOOK_NAME=%s", hook.Name),
-			fmt.Sprintf("DARCH_HOOK_DIR=%s", hook.Path),
-			fmt.Sprintf("DARCH_HOOK_DEST_DIR=%s", destinationHookDirectory),
-			fmt.Sprintf("DARCH_IMAGE_NAME=%s", association.Ref.FullName()),
-		}...)
-		cmd.Dir = destinationHookDirectory
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Stdin = os.Stdin
-		err = cmd.Run()
+	ranAt := time.Now()
+	for id := range snapshotted {
+		err = session.imageStore.UpdateMetadata(id, func(metadata *reference.ImageMetadata) {
+			metadata.HooksRanAt = ranAt
+		})
 		if err != nil {
 			return err
 		}
 	}
 
-	// Now that we have ran the hooks, let's delete any hooks that may be in our image,
-	// and move our new hooks into it.
-	currentHooksDir := path.Join(DefaultStagingDirectoryImages, association.ID, "hooks")
+	return nil
+}
+
+func (installation *hookInstallation) hooksDir() string {
+	return path.Join(DefaultStagingDirectoryImages, installation.id, "hooks")
+}
+
+// commit Swaps the new hooks into the stage directory, keeping the current hooks as a backup.
+func (installation *hookInstallation) commit() error {
+	currentHooksDir := installation.hooksDir()
+
 	if utils.DirectoryExists(currentHooksDir) {
-		err = os.RemoveAll(currentHooksDir)
+		installation.backupDir = fmt.Sprintf("%s.%s", currentHooksDir, utils.NewID())
+		err := os.Rename(currentHooksDir, installation.backupDir)
 		if err != nil {
 			return err
 		}
 	}
 
-	err = os.Rename(path.Join(ws.Path, "hooks"), currentHooksDir)
+	err := os.Rename(path.Join(installation.ws.Path, "hooks"), currentHooksDir)
+	if err != nil {
+		if len(installation.backupDir) > 0 {
+			os.Rename(installation.backupDir, currentHooksDir)
+			installation.backupDir = ""
+		}
+		return err
+	}
+
+	installation.committed = true
+	return nil
+}
+
+// rollback Restores the hooks that were replaced by commit.
+func (installation *hookInstallation) rollback() error {
+	if !installation.committed {
+		return nil
+	}
+
+	currentHooksDir := installation.hooksDir()
+	err := os.RemoveAll(currentHooksDir)
 	if err != nil {
 		return err
 	}
+	if len(installation.backupDir) > 0 {
+		err = os.Rename(installation.backupDir, currentHooksDir)
+		if err != nil {
+			return err
+		}
+		installation.backupDir = ""
+	}
 
+	installation.committed = false
 	return nil
 }
+
+// finish Removes the hooks that were replaced, once every installation succeeded.
+func (installation *hookInstallation) finish() {
+	if len(installation.backupDir) > 0 {
+		os.RemoveAll(installation.backupDir)
+		installation.backupDir = ""
+	}
+}
+
+// discard Removes the output of the hooks, if it wasn't installed.
+func (installation *hookInstallation) discard() {
+	installation.ws.Destroy()
+}
+
+// recordInstallation Records the run that produced the hooks, now that it is known if they were installed.
+func (session *Session) recordInstallation(installation *hookInstallation, installed bool) {
+	if installation.run == nil {
+		return
+	}
+	installation.run.Installed = installed
+	err := session.recordHookRun(installation.id, installation.run)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: couldn't record the hook run for %s: %v\n", installation.run.Image, err)
+	}
+	installation.run = nil
+}
+
+// getHookEnvironment The environment variables describing the hook, and the stage it is ran for.
+func getHookEnvironment(hook hooks.Hook, params map[string]string, association reference.Association, stagedImage StagedImage, destinationHookDirectory string) []string {
+	result := []string{
+		fmt.Sprintf("DARCH_HOOKS_DIR=%s", hook.HooksPath),
+		fmt.Sprintf("DARCH_HOOK_NAME=%s", hook.Name),
+		fmt.Sprintf("DARCH_HOOK_DIR=%s", hook.Path),
+		fmt.Sprintf("DARCH_HOOK_DEST_DIR=%s", destinationHookDirectory),
+		fmt.Sprintf("DARCH_IMAGE_NAME=%s", association.Ref.FullName()),
+		fmt.Sprintf("DARCH_STAGE_ID=%s", association.ID),
+		fmt.Sprintf("DARCH_STAGE_DIR=%s", stagedImage.Dir),
+		fmt.Sprintf("DARCH_IMAGE_KERNEL=%s", stagedImage.Kernel),
+		fmt.Sprintf("DARCH_IMAGE_KERNEL_PARAMS=%s", stagedImage.KernelParams),
+		fmt.Sprintf("DARCH_IMAGE_INITRAMFS=%s", stagedImage.InitRAMFS),
+		fmt.Sprintf("DARCH_IMAGE_ROOTFS=%s", stagedImage.RootFS),
+		fmt.Sprintf("DARCH_IMAGE_NO_DOUBLE_MOUNT=%t", stagedImage.NoDoubleMount),
+	}
+
+	result = append(result, hooks.GetParamsEnvironment(params)...)
+
+	// Labels become DARCH_IMAGE_LABEL_<NAME>, with anything that isn't valid in a variable name replaced by "_".
+	labels := make([]string, 0, len(stagedImage.Labels))
+	for label := range stagedImage.Labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		result = append(result, fmt.Sprintf("DARCH_IMAGE_LABEL_%s=%s", hooks.EnvironmentName(label), stagedImage.Labels[label]))
+	}
+
+	return result
+}
+
+// runSandboxedHook Runs a hook script in the sandbox, killing it if it doesn't finish within the timeout.
+func runSandboxedHook(config HookSandboxConfiguration, destinationHookDirectory string, environment []string, script string, output io.Writer) error {
+	ctx := context.Background()
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	cmd, err := hooks.SandboxCommand(ctx, destinationHookDirectory, environment, script)
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err = cmd.Run()
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v", config.Timeout)
+	}
+	return err
+}
+
+// runHook Runs a single hook, writing its output to the destination directory.
+func (session *Session) runHook(hook hooks.Hook, params map[string]string, association reference.Association, stagedImage StagedImage, destinationHookDirectory string, stdin io.Reader, output io.Writer) error {
+	if hook.Builtin != nil {
+		return hooks.InstallBuiltinHook(hook, hooks.InstallContext{
+			HooksPath:            hook.HooksPath,
+			DestinationDirectory: destinationHookDirectory,
+			ImageRef:             association.Ref,
+			Params:               params,
+			Output:               output,
+		})
+	}
+
+	hookFile := path.Join(hook.Path, "hook")
+	if !utils.FileExists(hookFile) {
+		return fmt.Errorf("Hook script %s doesn't exist", hookFile)
+	}
+
+	err := utils.CopyFile(hookFile, path.Join(destinationHookDirectory, "hook"))
+	if err != nil {
+		return err
+	}
+
+	script := ". " + path.Join(destinationHookDirectory, "hook") + " && install"
+	environment := getHookEnvironment(hook, params, association, stagedImage, destinationHookDirectory)
+	if session.config.HookSandbox.Enabled {
+		return runSandboxedHook(session.config.HookSandbox, destinationHookDirectory, environment, script, output)
+	}
+
+	cmd := exec.Command("/bin/bash", "-c", script)
+	cmd.Env = append(os.Environ(), environment...)
+	cmd.Dir = destinationHookDirectory
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.Stdin = stdin
+	return cmd.Run()
+}
+
+// runHooksForAssociation Runs the hooks for an image into a temporary directory, without touching the stage directory.
+// If it fails, the output of the hooks is already discarded.
+func (session *Session) runHooksForAssociation(association reference.Association, hs []hooks.Hook, stdin io.Reader, output io.Writer) (*hookInstallation, error) {
+
+	ws, err := workspace.NewWorkspace(DefaultStagingDirectoryTmp)
+	if err != nil {
+		return nil, err
+	}
+	installation := &hookInstallation{
+		id: association.ID,
+		ws: ws,
+	}
+	failed := true
+	defer func() {
+		if failed {
+			installation.discard()
+		}
+	}()
+
+	stagedImage, err := parseImageDir(path.Join(DefaultStagingDirectoryImages, association.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	// Stages with no hooks get an empty hooks directory.
+	err = os.MkdirAll(path.Join(ws.Path, "hooks"), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	run, logFile, err := newHookRun(association)
+	if err != nil {
+		return nil, err
+	}
+	// A run that succeeded is recorded once it is known if its hooks were installed.
+	defer func() {
+		logFile.Close()
+		run.Duration = time.Since(run.StartedAt)
+		run.Succeeded = !failed
+		if !failed {
+			installation.run = run
+			return
+		}
+		if err := session.recordHookRun(association.ID, run); err != nil {
+			fmt.Fprintf(output, "couldn't record the hook run: %v\n", err)
+		}
+	}()
+	output = io.MultiWriter(output, logFile)
+
+	fail := func(hook hooks.Hook, started time.Time, err error) error {
+		run.addHook(hook.Name, started, err)
+		return HookFailure{
+			Image: association.Ref.FullName(),
+			Hook:  hook.Name,
+			Err:   err,
+		}
+	}
+
+	for _, hook := range hs {
+		started := time.Now()
+
+		applies, err := hooks.AppliesToImage(hook, association.Ref)
+		if err != nil {
+			return nil, fail(hook, started, err)
+		}
+		if !applies {
+			continue
+		}
+
+		if unsatisfied := hooks.GetUnsatisfiedRequirements(hook); len(unsatisfied) > 0 {
+			return nil, fail(hook, started, fmt.Errorf("requires %s, which doesn't exist on this host", strings.Join(unsatisfied, ", ")))
+		}
+
+		params, err := hooks.GetHookParams(hook, association.Ref)
+		if err != nil {
+			return nil, fail(hook, started, err)
+		}
+
+		var destinationHookDirectory = path.Join(ws.Path, "hooks", hook.NameWithOrder)
+
+		err = os.MkdirAll(destinationHookDirectory, os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Fprintf(output, "running hook %s\n", hook.Name)
+		err = session.runHook(hook, params, association, stagedImage, destinationHookDirectory, stdin, output)
+		if err == nil {
+			_, err = hooks.LoadManifest(destinationHookDirectory)
+		}
+		if err != nil {
+			return nil, fail(hook, started, err)
+		}
+		run.addHook(hook.Name, started, nil)
+	}
+
+	failed = false
+	return installation, nil
+}
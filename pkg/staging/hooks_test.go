@@ -0,0 +1,310 @@
+package staging
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/godarch/darch/pkg/hooks"
+	"github.com/godarch/darch/pkg/reference"
+	"github.com/godarch/darch/pkg/utils"
+	"github.com/godarch/darch/pkg/workspace"
+)
+
+func createTestHookInstallation(t *testing.T, dir string, id string, marker string) *hookInstallation {
+	ws, err := workspace.NewWorkspace(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(marker) > 0 {
+		if err = os.MkdirAll(path.Join(ws.Path, "hooks"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path.Join(ws.Path, "hooks", marker), []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &hookInstallation{id: id, ws: ws}
+}
+
+func TestInstallHooksRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "darch-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	previous, previousRuns, previousHooks := DefaultStagingDirectoryImages, DefaultHookRunsDirectory, hooks.DefaultHooksPath
+	DefaultStagingDirectoryImages = path.Join(dir, "live")
+	DefaultHookRunsDirectory = path.Join(dir, "runs")
+	hooks.DefaultHooksPath = path.Join(dir, "hooks")
+	defer func() {
+		DefaultStagingDirectoryImages, DefaultHookRunsDirectory, hooks.DefaultHooksPath = previous, previousRuns, previousHooks
+	}()
+
+	// Both stages have hooks installed already.
+	for _, id := range []string{"stage1", "stage2"} {
+		if err = os.MkdirAll(path.Join(DefaultStagingDirectoryImages, id, "hooks"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path.Join(DefaultStagingDirectoryImages, id, "hooks", "old"), []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ref, err := reference.ParseImage("desktop:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, logFile, err := newHookRun(reference.Association{Ref: ref, ID: "stage1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logFile.Close()
+	run.Succeeded = true
+
+	// The second installation has no hooks directory, so it can't be installed.
+	session := &Session{}
+	installation := createTestHookInstallation(t, dir, "stage1", "new")
+	installation.run = run
+	err = session.installHooks([]*hookInstallation{
+		installation,
+		createTestHookInstallation(t, dir, "stage2", ""),
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// The hooks of the first stage succeeded, but they weren't installed.
+	status, err := session.GetHooksStatus(StagedImageNamed{Ref: ref, ID: "stage1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.LastRun == nil || !status.LastRun.Succeeded || status.LastRun.Installed || !status.Stale {
+		t.Fatalf("expected the run to be recorded as not installed, got %v", status.LastRun)
+	}
+
+	for _, id := range []string{"stage1", "stage2"} {
+		hooksDir := path.Join(DefaultStagingDirectoryImages, id, "hooks")
+		if !utils.FileExists(path.Join(hooksDir, "old")) || utils.FileExists(path.Join(hooksDir, "new")) {
+			t.Fatalf("expected the old hooks to be restored for %s", id)
+		}
+		children, err := utils.GetChildDirectories(path.Join(DefaultStagingDirectoryImages, id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(children) != 1 {
+			t.Fatalf("expected no backups left for %s, got %v", id, children)
+		}
+	}
+}
+
+func TestInstallHooksRollbackAfterCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "darch-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	previous := DefaultStagingDirectoryImages
+	DefaultStagingDirectoryImages = path.Join(dir, "live")
+	defer func() { DefaultStagingDirectoryImages = previous }()
+
+	hooksDir := path.Join(DefaultStagingDirectoryImages, "stage1", "hooks")
+	if err = os.MkdirAll(hooksDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path.Join(hooksDir, "old"), []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new hooks are committed, but can't be snapshotted because of the invalid manifest.
+	installation := createTestHookInstallation(t, dir, "stage1", "new")
+	if err = os.MkdirAll(path.Join(installation.ws.Path, "hooks", "example"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path.Join(installation.ws.Path, "hooks", "example", hooks.ManifestFileName), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	session := &Session{}
+	if err = session.installHooks([]*hookInstallation{installation}); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if !utils.FileExists(path.Join(hooksDir, "old")) || utils.FileExists(path.Join(hooksDir, "new")) {
+		t.Fatal("expected the old hooks to be restored")
+	}
+	children, err := utils.GetChildDirectories(path.Join(DefaultStagingDirectoryImages, "stage1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(children) != 1 {
+		t.Fatalf("expected no backups left, got %v", children)
+	}
+}
+
+func TestGetHookEnvironment(t *testing.T) {
+	ref, err := reference.ParseImage("desktop:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := getHookEnvironment(
+		hooks.Hook{Name: "hostname", Path: "/etc/darch/hooks/hostname", HooksPath: "/etc/darch/hooks"},
+		map[string]string{"ssh-port": "22"},
+		reference.Association{Ref: ref, ID: "stage1"},
+		StagedImage{
+			Dir:          "/var/lib/darch/stage/live/stage1",
+			Kernel:       "vmlinuz",
+			KernelParams: "quiet",
+			Labels: map[string]string{
+				"org.opencontainers.image.version": "1.0",
+				"maintainer":                       "someone",
+			},
+		},
+		"/tmp/hooks/00000000_hostname")
+
+	expected := []string{
+		"DARCH_HOOK_DEST_DIR=/tmp/hooks/00000000_hostname",
+		"DARCH_IMAGE_NAME=desktop:latest",
+		"DARCH_STAGE_ID=stage1",
+		"DARCH_STAGE_DIR=/var/lib/darch/stage/live/stage1",
+		"DARCH_IMAGE_KERNEL=vmlinuz",
+		"DARCH_IMAGE_KERNEL_PARAMS=quiet",
+		"DARCH_IMAGE_NO_DOUBLE_MOUNT=false",
+		"DARCH_HOOK_PARAM_SSH_PORT=22",
+		"DARCH_IMAGE_LABEL_MAINTAINER=someone",
+		"DARCH_IMAGE_LABEL_ORG_OPENCONTAINERS_IMAGE_VERSION=1.0",
+	}
+	for _, e := range expected {
+		found := false
+		for _, actual := range env {
+			if actual == e {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("expected %s in %v", e, env)
+		}
+	}
+}
+
+func TestDiffHooksSnapshots(t *testing.T) {
+	previous := hooksSnapshot{Hooks: map[string]hookSnapshot{
+		"hostname": {
+			Files:    map[string]string{"hostname": "a", "manifest.json": "m"},
+			Manifest: &hooks.Manifest{Files: []hooks.ManifestFile{{Source: "hostname", Destination: "/etc/hostname"}}},
+		},
+		"old": {Files: map[string]string{"file": "a"}},
+	}}
+	current := hooksSnapshot{Hooks: map[string]hookSnapshot{
+		"hostname": {
+			Files:    map[string]string{"hostname": "b", "manifest.json": "m"},
+			Manifest: &hooks.Manifest{Files: []hooks.ManifestFile{{Source: "hostname", Destination: "/etc/hostname"}}},
+		},
+		"new": {Files: map[string]string{"file": "a"}},
+	}}
+
+	expected := []HookChange{
+		{Hook: "hostname", Path: "hostname", Change: "changed"},
+		{Hook: "hostname", Path: "/etc/hostname", Change: "changed", Injected: true},
+		{Hook: "new", Path: "file", Change: "added"},
+		{Hook: "old", Path: "file", Change: "removed"},
+	}
+	actual := diffHooksSnapshots(previous, current)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestRecordHookRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "darch-hook-runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	previousRuns, previousHooks := DefaultHookRunsDirectory, hooks.DefaultHooksPath
+	DefaultHookRunsDirectory = path.Join(dir, "runs")
+	hooks.DefaultHooksPath = path.Join(dir, "hooks")
+	defer func() { DefaultHookRunsDirectory, hooks.DefaultHooksPath = previousRuns, previousHooks }()
+
+	ref, err := reference.ParseImage("desktop:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	association := reference.Association{Ref: ref, ID: "stage1"}
+	image := StagedImageNamed{Ref: ref, ID: "stage1"}
+	session := &Session{}
+
+	status, err := session.GetHooksStatus(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.LastRun != nil || !status.Stale {
+		t.Fatal("expected hooks that never ran to be stale")
+	}
+
+	var firstOutput string
+	for i := 0; i < maxHookRuns+1; i++ {
+		run, logFile, err := newHookRun(association)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logFile.Close()
+		if i == 0 {
+			firstOutput = run.OutputPath
+		}
+		run.addHook("hostname", time.Now(), nil)
+		run.Succeeded = true
+		run.Installed = true
+		if err = session.recordHookRun(association.ID, run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runs, err := session.GetHookRuns(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != maxHookRuns || utils.FileExists(firstOutput) {
+		t.Fatalf("expected the oldest run to be removed, got %d runs", len(runs))
+	}
+
+	status, err = session.GetHooksStatus(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.LastRun == nil || status.Stale || len(status.LastRun.Hooks) != 1 {
+		t.Fatalf("unexpected status %v", status)
+	}
+
+	// Changing the hooks makes the run stale.
+	if err = os.MkdirAll(path.Join(hooks.DefaultHooksPath, "example"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	status, err = session.GetHooksStatus(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Stale {
+		t.Fatal("expected the hooks to be stale")
+	}
+}
+
+func TestGetExitStatus(t *testing.T) {
+	err := exec.Command("/bin/sh", "-c", "exit 3").Run()
+	if status := getExitStatus(err); status != 3 {
+		t.Fatalf("expected 3, got %d", status)
+	}
+	if status := getExitStatus(fmt.Errorf("failed")); status != -1 {
+		t.Fatalf("expected -1, got %d", status)
+	}
+	if status := getExitStatus(nil); status != 0 {
+		t.Fatalf("expected 0, got %d", status)
+	}
+}
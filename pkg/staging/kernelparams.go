@@ -0,0 +1,129 @@
+package staging
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/godarch/darch/pkg/hooks"
+	"github.com/godarch/darch/pkg/reference"
+)
+
+var (
+	// DefaultKernelParamsLocation Where the kernel parameters for this machine are configured.
+	DefaultKernelParamsLocation = "/etc/darch/kernel-params"
+)
+
+const (
+	// kernelParamsAnnotation The annotation in the stage database holding the kernel params for a single stage.
+	kernelParamsAnnotation = "kernel-params"
+)
+
+// KernelParamsEntry Kernel parameters to add to every image matching a glob.
+type KernelParamsEntry struct {
+	Glob   string
+	Params string
+	g      hooks.ImageGlob
+}
+
+// LoadKernelParams Loads the kernel params configured for this machine.
+// Every line is a glob (matched like the images of a hook), followed by the params for matching images.
+// ---------
+// # Comments are ignored.
+// *                 quiet
+// desktop:*         nvidia-drm.modeset=1
+// ---------
+func LoadKernelParams() ([]KernelParamsEntry, error) {
+	f, err := os.Open(DefaultKernelParamsLocation)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []KernelParamsEntry{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseKernelParams(f)
+}
+
+func parseKernelParams(r io.Reader) ([]KernelParamsEntry, error) {
+	result := []KernelParamsEntry{}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected an image glob followed by kernel params", DefaultKernelParamsLocation, lineNumber)
+		}
+
+		g, err := hooks.CompileImageGlob(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid glob %s: %v", DefaultKernelParamsLocation, lineNumber, fields[0], err)
+		}
+
+		result = append(result, KernelParamsEntry{
+			Glob:   fields[0],
+			Params: strings.Join(fields[1:], " "),
+			g:      g,
+		})
+	}
+
+	return result, scanner.Err()
+}
+
+// getKernelParamsForImage Merges the params of every entry matching the image, in the order they are configured.
+func getKernelParamsForImage(entries []KernelParamsEntry, imageRef reference.ImageRef) string {
+	result := []string{}
+	for _, entry := range entries {
+		if entry.g.Matches(imageRef) {
+			result = append(result, entry.Params)
+		}
+	}
+	return strings.Join(result, " ")
+}
+
+// GetKernelParams Get the kernel params set for a single stage with SetKernelParams.
+func (session *Session) GetKernelParams(image StagedImageNamed) (string, error) {
+	return session.imageStore.GetAnnotation(image.ID, kernelParamsAnnotation)
+}
+
+// SetKernelParams Set the kernel params for a single stage. An empty value removes them.
+func (session *Session) SetKernelParams(image StagedImageNamed, params string) error {
+	return session.imageStore.SetAnnotation(image.ID, kernelParamsAnnotation, strings.TrimSpace(params))
+}
+
+// GetEffectiveKernelParams Get every kernel param an image boots with (besides the ones darch uses internally).
+// These are the params from the image itself, followed by the ones in DefaultKernelParamsLocation
+// and the ones set for the stage.
+// DefaultKernelParamsLocation is only loaded here, so that a mistake in it doesn't break every stage command.
+func (session *Session) GetEffectiveKernelParams(image StagedImageNamed) (string, error) {
+	entries, err := LoadKernelParams()
+	if err != nil {
+		return "", err
+	}
+
+	result := []string{}
+	if len(image.KernelParams) > 0 {
+		result = append(result, image.KernelParams)
+	}
+	if params := getKernelParamsForImage(entries, image.Ref); len(params) > 0 {
+		result = append(result, params)
+	}
+	stageParams, err := session.GetKernelParams(image)
+	if err != nil {
+		return "", err
+	}
+	if len(stageParams) > 0 {
+		result = append(result, stageParams)
+	}
+	return strings.Join(result, " "), nil
+}
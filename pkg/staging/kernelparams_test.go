@@ -0,0 +1,50 @@
+package staging
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/godarch/darch/pkg/reference"
+)
+
+func TestKernelParamsForImage(t *testing.T) {
+	entries, err := parseKernelParams(strings.NewReader(`
+# Every image
+*              quiet
+desktop:*      nvidia-drm.modeset=1   splash
+base:latest    debug
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+
+	tests := map[string]string{
+		"desktop:latest": "quiet nvidia-drm.modeset=1 splash",
+		"base:latest":    "quiet debug",
+		"base:1":         "quiet",
+	}
+	for name, expected := range tests {
+		ref, err := reference.ParseImage(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		params := getKernelParamsForImage(entries, ref)
+		if params != expected {
+			t.Fatalf("expected \"%s\" for %s, got \"%s\"", expected, name, params)
+		}
+	}
+}
+
+func TestKernelParamsInvalid(t *testing.T) {
+	_, err := parseKernelParams(strings.NewReader("desktop:*\n"))
+	if err == nil {
+		t.Fatal("expected an error for a glob without params")
+	}
+	_, err = parseKernelParams(strings.NewReader("desktop:[ quiet\n"))
+	if err == nil {
+		t.Fatal("expected an error for an invalid glob")
+	}
+}
@@ -0,0 +1,51 @@
+package staging
+
+import (
+	"github.com/godarch/darch/pkg/reference"
+)
+
+// StageMetadata What is known about a staged image.
+// The boot state isn't stored, since /proc/cmdline and the grub environment block are what decide it.
+type StageMetadata struct {
+	reference.ImageMetadata
+	// Booted The stage is currently booted.
+	Booted bool
+	// Default The stage is what grub boots by default.
+	Default bool
+}
+
+// GetMetadata Get the metadata of a staged image.
+func (session *Session) GetMetadata(image StagedImageNamed) (StageMetadata, error) {
+	result := StageMetadata{}
+
+	metadata, err := session.imageStore.GetMetadata(image.ID)
+	if err != nil {
+		return result, err
+	}
+	result.ImageMetadata = metadata
+	if len(result.SourceDigest) == 0 {
+		// Stages uploaded before metadata was stored still have the digest in their image.json.
+		result.SourceDigest = image.SourceDigest
+	}
+
+	currentBootID, err := getCurrentBootedStageID()
+	if err != nil && err != reference.ErrDoesNotExist {
+		return result, err
+	}
+	result.Booted = currentBootID == image.ID
+
+	// A missing or unreadable environment block just means there is no default.
+	defaultImage, err := session.GetDefaultImage()
+	if err == nil {
+		result.Default = defaultImage.DisplayName() == image.DisplayName()
+	}
+
+	return result, nil
+}
+
+// SetNotes Replaces the notes of a staged image. Empty notes remove them.
+func (session *Session) SetNotes(image StagedImageNamed, notes string) error {
+	return session.imageStore.UpdateMetadata(image.ID, func(metadata *reference.ImageMetadata) {
+		metadata.Notes = notes
+	})
+}
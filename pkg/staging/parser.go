@@ -21,6 +21,16 @@ type StagedImage struct {
 	RootFS        string
 	NoDoubleMount bool
 	CreationTime  time.Time
+	// Digests The digests of the staged artifacts (kernel, initramfs, rootfs), recorded at upload.
+	Digests map[string]string
+	// VerityHashTree The file holding the dm-verity hash tree of the rootfs, if generated.
+	VerityHashTree string
+	// VerityRootHash The dm-verity root hash of the rootfs, if generated.
+	VerityRootHash string
+	// Labels The OCI labels of the image the stage was extracted from.
+	Labels map[string]string
+	// SourceDigest The digest of the image the stage was extracted from.
+	SourceDigest string
 }
 
 // StagedImageNamed A StagedImage with a name and tag
@@ -28,14 +38,30 @@ type StagedImageNamed struct {
 	StagedImage
 	Ref reference.ImageRef
 	ID  string
+	// Previous True if this is the version that was staged with this name before the current one.
+	Previous bool
+}
+
+// DisplayName The name of the staged image, with PreviousSuffix appended for previous versions.
+func (image StagedImageNamed) DisplayName() string {
+	if image.Previous {
+		return image.Ref.FullName() + PreviousSuffix
+	}
+	return image.Ref.FullName()
 }
 
 type stagedImageConfiguration struct {
-	Kernel        string `json:"kernel"`
-	KernelParams  string `json:"kernelparams"`
-	InitRAMFS     string `json:"initramfs"`
-	RootFS        string `json:"rootfs"`
-	NoDoubleMount bool   `json:"nodoublemount"`
+	Kernel         string            `json:"kernel"`
+	KernelParams   string            `json:"kernelparams"`
+	InitRAMFS      string            `json:"initramfs"`
+	RootFS         string            `json:"rootfs"`
+	NoDoubleMount  bool              `json:"nodoublemount"`
+	Digests        map[string]string `json:"digests,omitempty"`
+	VerityHashTree string            `json:"verityhashtree,omitempty"`
+	VerityRootHash string            `json:"verityroothash,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	SourceDigest   string            `json:"sourcedigest,omitempty"`
+	CreatedAt      time.Time         `json:"createdat"`
 }
 
 // ParseImageDir Parses an image directory, and also validates it.
@@ -76,9 +102,20 @@ func parseImageDir(imageDir string) (StagedImage, error) {
 		return result, fmt.Errorf("rootfs was invalid")
 	}
 
-	stat, err := os.Stat(imageDir)
-	if err != nil {
-		return result, err
+	if len(config.VerityHashTree) > 0 && !utils.FileExists(path.Join(imageDir, config.VerityHashTree)) {
+		return result, fmt.Errorf("verity hash tree was invalid")
+	}
+
+	// The creation time is recorded on extraction. Stages extracted before that use the time image.json was
+	// last written, which is when they were uploaded (digests and the verity root hash are added to it).
+	// The image directory itself is modified every time hooks are ran.
+	creationTime := config.CreatedAt
+	if creationTime.IsZero() {
+		stat, err := os.Stat(path.Join(imageDir, "image.json"))
+		if err != nil {
+			return result, err
+		}
+		creationTime = stat.ModTime()
 	}
 
 	result.InitRAMFS = config.InitRAMFS
@@ -86,7 +123,12 @@ func parseImageDir(imageDir string) (StagedImage, error) {
 	result.KernelParams = config.KernelParams
 	result.RootFS = config.RootFS
 	result.NoDoubleMount = config.NoDoubleMount
-	result.CreationTime = stat.ModTime()
+	result.Digests = config.Digests
+	result.VerityHashTree = config.VerityHashTree
+	result.VerityRootHash = config.VerityRootHash
+	result.Labels = config.Labels
+	result.SourceDigest = config.SourceDigest
+	result.CreationTime = creationTime
 
 	return result, nil
 }
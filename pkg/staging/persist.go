@@ -0,0 +1,304 @@
+package staging
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/pkg/ioutils"
+	"github.com/godarch/darch/pkg/hooks"
+	"github.com/godarch/darch/pkg/reference"
+	"github.com/godarch/darch/pkg/utils"
+)
+
+var (
+	// DefaultPersistLocation Where the paths to persist for each image are configured.
+	DefaultPersistLocation = "/etc/darch/persist"
+	// DefaultPersistDirectory Where the persisted paths of each image are stored, by image name.
+	DefaultPersistDirectory = "/var/lib/darch/persist"
+)
+
+const (
+	// persistFile The file in a stage directory listing the paths the initramfs should persist.
+	persistFile = "persist"
+)
+
+// PersistEntry Paths to persist for every image matching a glob.
+type PersistEntry struct {
+	Glob  string
+	Paths []string
+	g     hooks.ImageGlob
+}
+
+// LoadPersistEntries Loads the paths to persist for each image.
+// Every line is a glob (matched like the images of a hook), followed by the paths to persist for matching images.
+// ---------
+// # Comments are ignored.
+// *            /etc/machine-id
+// desktop:*    /var/lib/NetworkManager /var/lib/bluetooth
+// ---------
+func LoadPersistEntries() ([]PersistEntry, error) {
+	f, err := os.Open(DefaultPersistLocation)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []PersistEntry{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	return parsePersistEntries(f)
+}
+
+func parsePersistEntries(r io.Reader) ([]PersistEntry, error) {
+	result := []PersistEntry{}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected an image glob followed by paths", DefaultPersistLocation, lineNumber)
+		}
+
+		g, err := hooks.CompileImageGlob(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid glob %s: %v", DefaultPersistLocation, lineNumber, fields[0], err)
+		}
+
+		for _, p := range fields[1:] {
+			if err = validatePersistPath(p); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", DefaultPersistLocation, lineNumber, err)
+			}
+		}
+
+		result = append(result, PersistEntry{
+			Glob:  fields[0],
+			Paths: fields[1:],
+			g:     g,
+		})
+	}
+
+	return result, scanner.Err()
+}
+
+func validatePersistPath(p string) error {
+	if !path.IsAbs(p) {
+		return fmt.Errorf("%s isn't an absolute path", p)
+	}
+	if path.Clean(p) == "/" {
+		return fmt.Errorf("the root directory can't be persisted")
+	}
+	return nil
+}
+
+// GetPersistedPaths Get the paths persisted for an image, from every entry that matches it.
+func GetPersistedPaths(entries []PersistEntry, imageRef reference.ImageRef) []string {
+	result := []string{}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if !entry.g.Matches(imageRef) {
+			continue
+		}
+		for _, p := range entry.Paths {
+			p = path.Clean(p)
+			if !seen[p] {
+				seen[p] = true
+				result = append(result, p)
+			}
+		}
+	}
+	return result
+}
+
+// AddPersistedPath Persist a path for every image matching the glob.
+func AddPersistedPath(imageGlob string, p string) error {
+	if _, err := hooks.CompileImageGlob(imageGlob); err != nil {
+		return fmt.Errorf("invalid glob %s: %v", imageGlob, err)
+	}
+	if err := validatePersistPath(p); err != nil {
+		return err
+	}
+
+	entries, err := LoadPersistEntries()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Glob != imageGlob {
+			continue
+		}
+		for _, existing := range entry.Paths {
+			if path.Clean(existing) == path.Clean(p) {
+				return fmt.Errorf("%s is already persisted for %s", p, imageGlob)
+			}
+		}
+	}
+
+	return updatePersistLines(func(lines []string) []string {
+		return append(lines, fmt.Sprintf("%s %s", imageGlob, p))
+	})
+}
+
+// RemovePersistedPath Stop persisting a path for the glob. Returns false if it wasn't persisted.
+func RemovePersistedPath(imageGlob string, p string) (bool, error) {
+	removed := false
+	err := updatePersistLines(func(lines []string) []string {
+		result := []string{}
+		for _, line := range lines {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || fields[0] != imageGlob {
+				result = append(result, line)
+				continue
+			}
+			paths := []string{}
+			for _, existing := range fields[1:] {
+				if path.Clean(existing) == path.Clean(p) {
+					removed = true
+				} else {
+					paths = append(paths, existing)
+				}
+			}
+			if len(paths) == len(fields)-1 {
+				// Nothing removed, leave the formatting as is.
+				result = append(result, line)
+			} else if len(paths) > 0 {
+				result = append(result, fmt.Sprintf("%s %s", imageGlob, strings.Join(paths, " ")))
+			}
+		}
+		return result
+	})
+	return removed, err
+}
+
+// updatePersistLines Rewrites DefaultPersistLocation, leaving comments and unrelated lines as they are.
+func updatePersistLines(update func(lines []string) []string) error {
+	lines := []string{}
+	data, err := ioutil.ReadFile(DefaultPersistLocation)
+	if err == nil {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+		if len(lines) == 1 && len(lines[0]) == 0 {
+			lines = []string{}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	lines = update(lines)
+
+	// Make sure what we are writing is valid.
+	_, err = parsePersistEntries(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		return err
+	}
+
+	parentDir := path.Dir(DefaultPersistLocation)
+	if !utils.DirectoryExists(parentDir) {
+		err = os.MkdirAll(parentDir, os.ModePerm)
+		if err != nil {
+			return err
+		}
+	}
+
+	content := ""
+	if len(lines) > 0 {
+		content = strings.Join(lines, "\n") + "\n"
+	}
+	return ioutils.AtomicWriteFile(DefaultPersistLocation, []byte(content), 0644)
+}
+
+// getPersistDirectory Get the directory where the persisted paths of an image are stored.
+func getPersistDirectory(imageRef reference.ImageRef) string {
+	return path.Join(DefaultPersistDirectory, imageRef.Name())
+}
+
+// materializePersistedPaths Writes the paths to persist for a stage into its directory,
+// and makes sure the directories that store them exist.
+// When a stage is tagged with multiple names, the paths of every name are persisted.
+func (session *Session) materializePersistedPaths(id string, entries []PersistEntry) error {
+	stageFile := path.Join(DefaultStagingDirectoryImages, id, persistFile)
+
+	refs, err := session.imageStore.References(id)
+	if err != nil {
+		return err
+	}
+	previousRefs, err := session.previousStore.References(id)
+	if err != nil {
+		return err
+	}
+
+	paths := []string{}
+	seen := make(map[string]bool)
+	for _, ref := range append(refs, previousRefs...) {
+		refPaths := GetPersistedPaths(entries, ref)
+		if len(refPaths) == 0 {
+			continue
+		}
+		err = os.MkdirAll(getPersistDirectory(ref), 0700)
+		if err != nil {
+			return err
+		}
+		for _, p := range refPaths {
+			if !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+
+	if len(paths) == 0 {
+		err = os.Remove(stageFile)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	return ioutils.AtomicWriteFile(stageFile, []byte(strings.Join(paths, "\n")+"\n"), 0644)
+}
+
+// SyncPersistedPaths Updates the paths to persist in the stage directory of every image.
+func (session *Session) SyncPersistedPaths() error {
+	entries, err := LoadPersistEntries()
+	if err != nil {
+		return err
+	}
+
+	associations, err := session.imageStore.AllImages()
+	if err != nil {
+		return err
+	}
+	previousAssociations, err := session.previousStore.AllImages()
+	if err != nil {
+		return err
+	}
+
+	synced := make(map[string]bool)
+	for _, association := range append(associations, previousAssociations...) {
+		if synced[association.ID] {
+			continue
+		}
+		synced[association.ID] = true
+		err = session.materializePersistedPaths(association.ID, entries)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasPersistedPaths Returns true if the initramfs should persist paths for the staged image.
+func hasPersistedPaths(stagedImage StagedImageNamed) bool {
+	return utils.FileExists(path.Join(stagedImage.Dir, persistFile))
+}
@@ -0,0 +1,83 @@
+package staging
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/godarch/darch/pkg/reference"
+)
+
+func TestPersistedPaths(t *testing.T) {
+	entries, err := parsePersistEntries(strings.NewReader(`
+# Every image
+*            /etc/machine-id
+desktop:*    /var/lib/NetworkManager /var/lib/bluetooth/
+desktop:*    /etc/machine-id
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := reference.ParseImage("desktop:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := GetPersistedPaths(entries, ref)
+	expected := []string{"/etc/machine-id", "/var/lib/NetworkManager", "/var/lib/bluetooth"}
+	if strings.Join(paths, " ") != strings.Join(expected, " ") {
+		t.Fatalf("expected %v, got %v", expected, paths)
+	}
+}
+
+func TestPersistedPathsInvalid(t *testing.T) {
+	for _, content := range []string{"* relative/path\n", "* /\n", "desktop:*\n"} {
+		_, err := parsePersistEntries(strings.NewReader(content))
+		if err == nil {
+			t.Fatalf("expected an error for %s", content)
+		}
+	}
+}
+
+func TestAddRemovePersistedPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "darch-persist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	previous := DefaultPersistLocation
+	DefaultPersistLocation = path.Join(dir, "persist")
+	defer func() { DefaultPersistLocation = previous }()
+
+	err = ioutil.WriteFile(DefaultPersistLocation, []byte("# keep this comment\n* /etc/machine-id\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = AddPersistedPath("desktop:*", "/var/lib/NetworkManager"); err != nil {
+		t.Fatal(err)
+	}
+	if err = AddPersistedPath("desktop:*", "/var/lib/NetworkManager"); err == nil {
+		t.Fatal("expected an error adding the same path twice")
+	}
+
+	removed, err := RemovePersistedPath("*", "/etc/machine-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !removed {
+		t.Fatal("expected the path to be removed")
+	}
+
+	content, err := ioutil.ReadFile(DefaultPersistLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "# keep this comment\ndesktop:* /var/lib/NetworkManager\n"
+	if string(content) != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, string(content))
+	}
+}
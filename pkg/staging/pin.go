@@ -0,0 +1,61 @@
+package staging
+
+import (
+	"fmt"
+
+	"github.com/godarch/darch/pkg/reference"
+)
+
+// Pin Protects a staged image from being removed by Clean, Prune, or being replaced by a forced upload or tag.
+// The stage is pinned, so every name of the stage is protected.
+func (session *Session) Pin(image StagedImageNamed) error {
+	return session.setPinned(image.ID, true)
+}
+
+// Unpin Allows a staged image to be removed again.
+func (session *Session) Unpin(image StagedImageNamed) error {
+	return session.setPinned(image.ID, false)
+}
+
+func (session *Session) setPinned(id string, pinned bool) error {
+	return session.imageStore.UpdateMetadata(id, func(metadata *reference.ImageMetadata) {
+		metadata.Pinned = pinned
+	})
+}
+
+// isPinned Returns true if the stage id is pinned.
+func (session *Session) isPinned(id string) (bool, error) {
+	metadata, err := session.imageStore.GetMetadata(id)
+	if err != nil {
+		return false, err
+	}
+	return metadata.Pinned, nil
+}
+
+// getPinnedIDs Gets every pinned stage id.
+func (session *Session) getPinnedIDs() (map[string]bool, error) {
+	result := make(map[string]bool)
+	for _, id := range session.imageStore.MetadataIDs() {
+		pinned, err := session.isPinned(id)
+		if err != nil {
+			return result, err
+		}
+		if pinned {
+			result[id] = true
+		}
+	}
+	return result, nil
+}
+
+// releasePinned Makes sure a stage id that is about to lose the given name isn't pinned.
+// If unpin is true, the stage is unpinned instead of returning an error.
+func (session *Session) releasePinned(id string, name string, unpin bool) error {
+	pinned, err := session.isPinned(id)
+	if err != nil || !pinned {
+		return err
+	}
+	if !unpin {
+		return fmt.Errorf("%s is pinned, use --unpin to replace it", name)
+	}
+	return session.setPinned(id, false)
+}
@@ -0,0 +1,97 @@
+package staging
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/godarch/darch/pkg/reference"
+)
+
+func createTestPinSession(t *testing.T, keepPrevious bool) (*Session, func()) {
+	dir, err := ioutil.TempDir("", "darch-pin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageStore, err := reference.NewReferenceStore(path.Join(dir, "images.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousStore, err := reference.NewReferenceStore(path.Join(dir, "previous.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := &Session{
+		imageStore:    imageStore,
+		previousStore: previousStore,
+		config:        Configuration{KeepPrevious: keepPrevious},
+	}
+	return session, func() { os.RemoveAll(dir) }
+}
+
+func TestPinnedTagReplace(t *testing.T) {
+	session, cleanup := createTestPinSession(t, false)
+	defer cleanup()
+
+	ref, err := reference.ParseImage("desktop:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = session.addTag(ref, "stage1", false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err = session.setPinned("stage1", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = session.CheckReplaceable(ref, false); err == nil {
+		t.Fatal("expected replacing a pinned image to be refused")
+	}
+	if err = session.addTag(ref, "stage2", true, false); err == nil {
+		t.Fatal("expected a forced tag to refuse to replace a pinned image")
+	}
+	if association, _ := session.imageStore.Get(ref); association.ID != "stage1" {
+		t.Fatalf("expected the pinned image to be kept, got %s", association.ID)
+	}
+	if err = session.checkRemovable(session.imageStore, ref, ref.FullName()); err == nil {
+		t.Fatal("expected removing a pinned image to be refused")
+	}
+
+	// With unpin, the image is replaced and no longer pinned.
+	if err = session.addTag(ref, "stage2", true, true); err != nil {
+		t.Fatal(err)
+	}
+	if pinned, _ := session.isPinned("stage1"); pinned {
+		t.Fatal("expected the replaced image to be unpinned")
+	}
+}
+
+func TestPinnedPreviousReplace(t *testing.T) {
+	session, cleanup := createTestPinSession(t, true)
+	defer cleanup()
+
+	ref, err := reference.ParseImage("desktop:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"stage1", "stage2"} {
+		if err = session.addTag(ref, id, true, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = session.setPinned("stage1", true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next upload would replace the pinned previous version.
+	if err = session.addTag(ref, "stage3", true, false); err == nil {
+		t.Fatal("expected a forced tag to refuse to replace a pinned previous version")
+	}
+	if err = session.addTag(ref, "stage3", true, true); err != nil {
+		t.Fatal(err)
+	}
+	if previous, _ := session.previousStore.Get(ref); previous.ID != "stage2" {
+		t.Fatalf("unexpected previous version %s", previous.ID)
+	}
+}
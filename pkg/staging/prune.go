@@ -0,0 +1,152 @@
+package staging
+
+import (
+	"sort"
+	"time"
+
+	"github.com/godarch/darch/pkg/reference"
+)
+
+// RetentionPolicy Describes which staged images should be kept on the stage.
+type RetentionPolicy struct {
+	// KeepLast The number of newest images to keep. Zero disables this rule.
+	KeepLast int
+	// PerName Apply KeepLast to each image name individually, instead of the whole stage.
+	PerName bool
+	// OlderThan Only remove images older than this. Zero disables this rule.
+	OlderThan time.Duration
+}
+
+// IsEmpty Returns true if the policy would never remove anything.
+func (policy RetentionPolicy) IsEmpty() bool {
+	return policy.KeepLast <= 0 && policy.OlderThan <= 0
+}
+
+// Prune Untags the staged images that don't satisfy the given retention policy, and then cleans the stage.
+// The currently booted image, the default boot image and pinned images are never removed.
+// If dryRun is true, the images that would be removed are returned, but nothing is changed.
+func (session *Session) Prune(policy RetentionPolicy, dryRun bool) ([]StagedImageNamed, error) {
+	result := []StagedImageNamed{}
+
+	if policy.IsEmpty() {
+		return result, nil
+	}
+
+	allImages, err := session.GetAllStaged()
+	if err != nil {
+		return result, err
+	}
+
+	protectedIDs, err := session.getProtectedIDs()
+	if err != nil {
+		return result, err
+	}
+
+	// Previous versions aren't subject to the policy,
+	// they are removed along with the tag they belong to.
+	currentImages := []StagedImageNamed{}
+	previousImages := make(map[string]StagedImageNamed)
+	for _, image := range allImages {
+		if image.Previous {
+			previousImages[image.Ref.FullName()] = image
+		} else {
+			currentImages = append(currentImages, image)
+		}
+	}
+
+	for _, image := range selectImagesToPrune(currentImages, policy, time.Now()) {
+		if protectedIDs[image.ID] {
+			continue
+		}
+		result = append(result, image)
+		if previous, ok := previousImages[image.Ref.FullName()]; ok && !protectedIDs[previous.ID] {
+			result = append(result, previous)
+		}
+	}
+
+	if dryRun || len(result) == 0 {
+		return result, nil
+	}
+
+	for _, image := range result {
+		store := session.imageStore
+		if image.Previous {
+			store = session.previousStore
+		}
+		_, err = store.Delete(image.Ref)
+		if err != nil && err != reference.ErrDoesNotExist {
+			return result, err
+		}
+	}
+
+	return result, session.Clean()
+}
+
+// getProtectedIDs Gets the stage ids that should never be automatically removed.
+func (session *Session) getProtectedIDs() (map[string]bool, error) {
+	result := make(map[string]bool)
+
+	currentBootID, err := getCurrentBootedStageID()
+	if err == nil {
+		result[currentBootID] = true
+	} else if err != reference.ErrDoesNotExist {
+		return result, err
+	}
+
+	defaultImage, err := session.GetDefaultImage()
+	if err == nil {
+		result[defaultImage.ID] = true
+	} else if err != reference.ErrDoesNotExist {
+		return result, err
+	}
+
+	pinnedIDs, err := session.getPinnedIDs()
+	if err != nil {
+		return result, err
+	}
+	for id := range pinnedIDs {
+		result[id] = true
+	}
+
+	return result, nil
+}
+
+// selectImagesToPrune Returns the images that violate every rule of the policy.
+func selectImagesToPrune(images []StagedImageNamed, policy RetentionPolicy, now time.Time) []StagedImageNamed {
+	result := []StagedImageNamed{}
+
+	if policy.IsEmpty() {
+		return result
+	}
+
+	// Group the images, so that KeepLast can be applied to each group.
+	groups := make(map[string][]StagedImageNamed)
+	groupNames := []string{}
+	for _, image := range images {
+		groupName := ""
+		if policy.PerName {
+			groupName = image.Ref.Name()
+		}
+		if _, ok := groups[groupName]; !ok {
+			groupNames = append(groupNames, groupName)
+		}
+		groups[groupName] = append(groups[groupName], image)
+	}
+	sort.Strings(groupNames)
+
+	for _, groupName := range groupNames {
+		group := groups[groupName]
+		sort.Stable(sortStagedImageNamedByAgeDesc(group))
+		for index, image := range group {
+			if policy.KeepLast > 0 && index < policy.KeepLast {
+				continue
+			}
+			if policy.OlderThan > 0 && now.Sub(image.CreationTime) < policy.OlderThan {
+				continue
+			}
+			result = append(result, image)
+		}
+	}
+
+	return result
+}
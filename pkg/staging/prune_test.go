@@ -0,0 +1,95 @@
+package staging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/godarch/darch/pkg/reference"
+)
+
+func buildStagedImage(t *testing.T, name string, age time.Duration, now time.Time) StagedImageNamed {
+	ref, err := reference.ParseImage(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return StagedImageNamed{
+		StagedImage: StagedImage{
+			CreationTime: now.Add(-age),
+		},
+		Ref: ref,
+		ID:  name,
+	}
+}
+
+func TestPruneKeepLast(t *testing.T) {
+	now := time.Now()
+	images := []StagedImageNamed{
+		buildStagedImage(t, "base:1", 3*time.Hour, now),
+		buildStagedImage(t, "base:2", 2*time.Hour, now),
+		buildStagedImage(t, "desktop:1", 1*time.Hour, now),
+	}
+
+	result := selectImagesToPrune(images, RetentionPolicy{KeepLast: 2}, now)
+	if len(result) != 1 || result[0].Ref.FullName() != "base:1" {
+		t.Fatalf("expected base:1 to be pruned, got %v", result)
+	}
+
+	result = selectImagesToPrune(images, RetentionPolicy{KeepLast: 1, PerName: true}, now)
+	if len(result) != 1 || result[0].Ref.FullName() != "base:1" {
+		t.Fatalf("expected base:1 to be pruned, got %v", result)
+	}
+}
+
+func TestPruneOlderThan(t *testing.T) {
+	now := time.Now()
+	images := []StagedImageNamed{
+		buildStagedImage(t, "base:1", 40*24*time.Hour, now),
+		buildStagedImage(t, "base:2", 35*24*time.Hour, now),
+		buildStagedImage(t, "base:3", 1*time.Hour, now),
+	}
+
+	result := selectImagesToPrune(images, RetentionPolicy{OlderThan: 30 * 24 * time.Hour}, now)
+	if len(result) != 2 {
+		t.Fatalf("expected 2 images to be pruned, got %d", len(result))
+	}
+
+	// Combined, an image has to violate both rules.
+	result = selectImagesToPrune(images, RetentionPolicy{KeepLast: 2, OlderThan: 30 * 24 * time.Hour}, now)
+	if len(result) != 1 || result[0].Ref.FullName() != "base:1" {
+		t.Fatalf("expected base:1 to be pruned, got %v", result)
+	}
+}
+
+func TestPruneEmptyPolicy(t *testing.T) {
+	now := time.Now()
+	images := []StagedImageNamed{
+		buildStagedImage(t, "base:1", 40*24*time.Hour, now),
+	}
+
+	result := selectImagesToPrune(images, RetentionPolicy{}, now)
+	if len(result) != 0 {
+		t.Fatal("empty policy shouldn't prune anything")
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	values := map[string]time.Duration{
+		"":    0,
+		"30d": 30 * 24 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+		"12h": 12 * time.Hour,
+	}
+	for value, expected := range values {
+		age, err := ParseAge(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if age != expected {
+			t.Fatalf("expected %v for %s, got %v", expected, value, age)
+		}
+	}
+
+	if _, err := ParseAge("xd"); err == nil {
+		t.Fatal("invalid age should have failed")
+	}
+}
@@ -1,12 +1,34 @@
 package staging
 
-import "github.com/godarch/darch/pkg/reference"
+import (
+	"fmt"
 
-// Remove Removes an image from the stage.
+	"github.com/godarch/darch/pkg/reference"
+)
+
+// Remove Removes an image, and its previous version, from the stage.
+// Pinned images must be unpinned first.
 func (session *Session) Remove(imageRef reference.ImageRef) error {
+	err := session.checkRemovable(session.imageStore, imageRef, imageRef.FullName())
+	if err != nil {
+		return err
+	}
+	err = session.checkRemovable(session.previousStore, imageRef, imageRef.FullName()+PreviousSuffix)
+	if err != nil {
+		return err
+	}
+
 	result, err := session.imageStore.Delete(imageRef)
+	if err != nil && err != reference.ErrDoesNotExist {
+		return err
+	}
 
-	if result {
+	previousResult, previousErr := session.previousStore.Delete(imageRef)
+	if previousErr != nil && previousErr != reference.ErrDoesNotExist {
+		return previousErr
+	}
+
+	if result || previousResult {
 		// We deleted the image.
 		// Let's do a clean up, which will delete the local data,
 		// if it isn't referenced anymore.
@@ -15,3 +37,38 @@ func (session *Session) Remove(imageRef reference.ImageRef) error {
 
 	return err
 }
+
+// RemovePrevious Removes only the previous version of an image from the stage.
+func (session *Session) RemovePrevious(imageRef reference.ImageRef) error {
+	err := session.checkRemovable(session.previousStore, imageRef, imageRef.FullName()+PreviousSuffix)
+	if err != nil {
+		return err
+	}
+
+	result, err := session.previousStore.Delete(imageRef)
+
+	if result {
+		return session.Clean()
+	}
+
+	return err
+}
+
+// checkRemovable Returns an error if the tag in the store points to a pinned stage.
+func (session *Session) checkRemovable(store reference.Store, imageRef reference.ImageRef, name string) error {
+	association, err := store.Get(imageRef)
+	if err == reference.ErrDoesNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pinned, err := session.isPinned(association.ID)
+	if err != nil {
+		return err
+	}
+	if pinned {
+		return fmt.Errorf("%s is pinned, unpin it first", name)
+	}
+	return nil
+}
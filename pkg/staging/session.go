@@ -2,6 +2,7 @@ package staging
 
 import (
 	"os"
+	"sync"
 
 	"github.com/godarch/darch/pkg/reference"
 	"github.com/godarch/darch/pkg/utils"
@@ -9,8 +10,12 @@ import (
 
 // Session A staging session.
 type Session struct {
-	imageStore reference.Store
-	imagesDir  string
+	imageStore    reference.Store
+	previousStore reference.Store
+	imagesDir     string
+	config        Configuration
+	// hookRunsLock Guards the logs of the hook runs, which are written by every image that hooks run for.
+	hookRunsLock sync.Mutex
 }
 
 // NewSession Create a new staging session.
@@ -20,6 +25,16 @@ func NewSession() (*Session, error) {
 		return nil, err
 	}
 
+	previousStore, err := reference.NewReferenceStore(DefaultStagingPreviousImagesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := LoadConfiguration()
+	if err != nil {
+		return nil, err
+	}
+
 	if !utils.DirectoryExists(DefaultStagingDirectoryImages) {
 		err = os.MkdirAll(DefaultStagingDirectoryImages, os.ModePerm)
 		if err != nil {
@@ -28,7 +43,14 @@ func NewSession() (*Session, error) {
 	}
 
 	return &Session{
-		imageStore: imageStore,
-		imagesDir:  DefaultStagingDirectoryImages,
+		imageStore:    imageStore,
+		previousStore: previousStore,
+		imagesDir:     DefaultStagingDirectoryImages,
+		config:        config,
 	}, nil
 }
+
+// Configuration The stage configuration this session was created with.
+func (session *Session) Configuration() Configuration {
+	return session.config
+}
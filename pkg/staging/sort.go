@@ -1,22 +1,22 @@
 package staging
 
 // sortStageImageNamedByName implements sort.Interface for []StagedImageNamed
-// based on the FullName field in an ascending order.
+// based on the DisplayName in an ascending order.
 type sortStagedImageNamedByName []StagedImageNamed
 
 func (a sortStagedImageNamedByName) Len() int { return len(a) }
 func (a sortStagedImageNamedByName) Less(i, j int) bool {
-	return a[i].Ref.FullName() < a[j].Ref.FullName()
+	return a[i].DisplayName() < a[j].DisplayName()
 }
 func (a sortStagedImageNamedByName) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 
 // sortStageImageNamedByNameDesc implements sort.Interface for []StagedImageNamed
-// based on the FullName field in an descending order.
+// based on the DisplayName in an descending order.
 type sortStagedImageNamedByNameDesc []StagedImageNamed
 
 func (a sortStagedImageNamedByNameDesc) Len() int { return len(a) }
 func (a sortStagedImageNamedByNameDesc) Less(i, j int) bool {
-	return a[i].Ref.FullName() > a[j].Ref.FullName()
+	return a[i].DisplayName() > a[j].DisplayName()
 }
 func (a sortStagedImageNamedByNameDesc) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 
@@ -2,6 +2,7 @@ package staging
 
 import (
 	"fmt"
+	"github.com/godarch/darch/pkg/grub"
 	"github.com/godarch/darch/pkg/reference"
 	"io/ioutil"
 	"path"
@@ -18,13 +19,38 @@ var (
 	DefaultStagingDirectoryTmp = path.Join(DefaultStagingDirectory, "tmp")
 	// DefaultStagingImagesFile File where our staged images information lives.
 	DefaultStagingImagesFile = path.Join(DefaultStagingDirectory, "images.json")
+	// DefaultStagingPreviousImagesFile File where the previous version of each staged image is tracked.
+	DefaultStagingPreviousImagesFile = path.Join(DefaultStagingDirectory, "previous.json")
 )
 
-// GetAllStaged Get all the staged items in the given directory.
+const (
+	// PreviousSuffix The suffix given to the name of the previous version of a staged image.
+	PreviousSuffix = "@prev"
+)
+
+// GetAllStaged Get all the staged items in the given directory, including previous versions.
 func (session *Session) GetAllStaged() ([]StagedImageNamed, error) {
+	result, err := session.getStaged(session.imageStore, false)
+	if err != nil {
+		return result, err
+	}
+
+	previous, err := session.getStaged(session.previousStore, true)
+	if err != nil {
+		return result, err
+	}
+	result = append(result, previous...)
+
+	// Sort the images.
+	sort.Sort(sortStagedImageNamedByName(result))
+
+	return result, nil
+}
+
+func (session *Session) getStaged(store reference.Store, previous bool) ([]StagedImageNamed, error) {
 	result := []StagedImageNamed{}
 
-	associations, err := session.imageStore.AllImages()
+	associations, err := store.AllImages()
 	if err != nil {
 		return result, err
 	}
@@ -39,15 +65,35 @@ func (session *Session) GetAllStaged() ([]StagedImageNamed, error) {
 			StagedImage: image,
 			Ref:         association.Ref,
 			ID:          association.ID,
+			Previous:    previous,
 		})
 	}
 
-	// Sort the images.
-	sort.Sort(sortStagedImageNamedByName(result))
-
 	return result, nil
 }
 
+// GetStaged Get a single staged image by name.
+// Returns reference.ErrDoesNotExist if it isn't staged.
+func (session *Session) GetStaged(imageRef reference.ImageRef, previous bool) (StagedImageNamed, error) {
+	allStagedImages, err := session.GetAllStaged()
+	if err != nil {
+		return StagedImageNamed{}, err
+	}
+	for _, stagedImage := range allStagedImages {
+		if stagedImage.Ref.FullName() == imageRef.FullName() && stagedImage.Previous == previous {
+			return stagedImage, nil
+		}
+	}
+	return StagedImageNamed{}, reference.ErrDoesNotExist
+}
+
+// ParseStagedName Parses the name of a staged image, which may have PreviousSuffix appended to it.
+func ParseStagedName(val string) (reference.ImageRef, bool, error) {
+	previous := strings.HasSuffix(val, PreviousSuffix)
+	ref, err := reference.ParseImage(strings.TrimSuffix(val, PreviousSuffix))
+	return ref, previous, err
+}
+
 // IsStaged Is the given reference currently staged?
 func (session *Session) IsStaged(imageRef reference.ImageRef) (bool, error) {
 	_, err := session.imageStore.Get(imageRef)
@@ -65,11 +111,59 @@ func (session *Session) IsStaged(imageRef reference.ImageRef) (bool, error) {
 // Returns reference.ErrDoesNotExist if entry isn't present.
 func (session *Session) GetCurrentBootedImage() (StagedImageNamed, error) {
 	result := StagedImageNamed{}
-	cmdLineBytes, err := ioutil.ReadFile("/proc/cmdline")
 
+	stageID, err := getCurrentBootedStageID()
+	if err != nil {
+		return result, err
+	}
+
+	allStagedImages, err := session.GetAllStaged()
+	if err != nil {
+		return result, err
+	}
+	for _, stagedImage := range allStagedImages {
+		if stagedImage.ID == stageID {
+			return stagedImage, nil
+		}
+	}
+	return result, fmt.Errorf("staged id %s wasn't found", stageID)
+}
+
+// GetDefaultImage Looks at the grub environment block to determine which image grub will boot by default.
+// Returns reference.ErrDoesNotExist if the default entry isn't a staged image.
+func (session *Session) GetDefaultImage() (StagedImageNamed, error) {
+	result := StagedImageNamed{}
+
+	env, err := grub.ReadEnvironmentBlock(grub.DefaultEnvironmentBlockPath)
+	if err != nil {
+		return result, err
+	}
+
+	title := grub.DefaultEntryTitle(env)
+	if len(title) == 0 {
+		return result, reference.ErrDoesNotExist
+	}
+
+	allStagedImages, err := session.GetAllStaged()
 	if err != nil {
 		return result, err
 	}
+	for _, stagedImage := range allStagedImages {
+		if getMenuEntryTitle(stagedImage) == title {
+			return stagedImage, nil
+		}
+	}
+
+	return result, reference.ErrDoesNotExist
+}
+
+// getCurrentBootedStageID Looks at /proc/cmdline for the stage id that is currently booted.
+// Returns reference.ErrDoesNotExist if entry isn't present.
+func getCurrentBootedStageID() (string, error) {
+	cmdLineBytes, err := ioutil.ReadFile("/proc/cmdline")
+	if err != nil {
+		return "", err
+	}
 
 	cmdLine := string(cmdLineBytes)
 
@@ -80,20 +174,11 @@ func (session *Session) GetCurrentBootedImage() (StagedImageNamed, error) {
 		if strings.HasPrefix(cmdLineArg, "darch_stageid=") {
 			stageID := cmdLineArg[len("darch_stageid="):]
 			if len(stageID) == 0 {
-				return result, fmt.Errorf("invalid stage id")
+				return "", fmt.Errorf("invalid stage id")
 			}
-			allStagedImages, err := session.GetAllStaged()
-			if err != nil {
-				return result, err
-			}
-			for _, stagedImage := range allStagedImages {
-				if stagedImage.ID == stageID {
-					return stagedImage, nil
-				}
-			}
-			return result, fmt.Errorf("staged id %s wasn't found", stageID)
+			return stageID, nil
 		}
 	}
 
-	return result, reference.ErrDoesNotExist
+	return "", reference.ErrDoesNotExist
 }
@@ -3,11 +3,12 @@ package staging
 import "github.com/godarch/darch/pkg/reference"
 
 // Tag Tag a staged image as something else.
-func (session *Session) Tag(sourceImageRef, destinationImageRef reference.ImageRef, force bool) error {
+// Forcing the tag doesn't replace a pinned image, unless unpin is true.
+func (session *Session) Tag(sourceImageRef, destinationImageRef reference.ImageRef, force bool, unpin bool) error {
 	sourceID, err := session.imageStore.Get(sourceImageRef)
 	if err != nil {
 		return err
 	}
 
-	return session.imageStore.AddTag(destinationImageRef, sourceID.ID, force)
+	return session.addTag(destinationImageRef, sourceID.ID, force, unpin)
 }
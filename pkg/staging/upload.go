@@ -3,14 +3,18 @@ package staging
 import (
 	"fmt"
 	"os"
+	"os/user"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/godarch/darch/pkg/reference"
 	"github.com/godarch/darch/pkg/utils"
 )
 
 // UploadDirectoryWithMove Moves (not copy) a directory to staging for boot.
-func (session *Session) UploadDirectoryWithMove(imageDir string, imageRef reference.ImageRef, force bool) error {
+// Forcing the upload doesn't replace a pinned image, unless unpin is true.
+func (session *Session) UploadDirectoryWithMove(imageDir string, imageRef reference.ImageRef, force bool, unpin bool) error {
 	// If we aren't forcing this upload, we don't intend to overwrite images already on the stage.
 	// So, let's do a quick check to see if it exists.
 	if !force {
@@ -21,11 +25,30 @@ func (session *Session) UploadDirectoryWithMove(imageDir string, imageRef refere
 		}
 	}
 
+	// Check before moving anything into the stage, addTag checks again once the stage is ready.
+	err := session.CheckReplaceable(imageRef, unpin)
+	if err != nil {
+		return err
+	}
+
 	img, err := parseImageDir(imageDir)
 	if err != nil {
 		return err
 	}
 
+	if session.config.Verity {
+		err = generateVerityHashTree(&img)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Record what we are staging, so that it can be verified later.
+	err = recordDigests(img)
+	if err != nil {
+		return err
+	}
+
 	// TODO: Need to make sure this doesn't exist. It likely wont.
 	newID := utils.NewID()
 	newDir := path.Join(session.imagesDir, newID)
@@ -39,12 +62,91 @@ func (session *Session) UploadDirectoryWithMove(imageDir string, imageRef refere
 
 	img.Dir = newDir
 
-	err = session.imageStore.AddTag(imageRef, newID, force)
+	err = session.addTag(imageRef, newID, force, unpin)
 	if err != nil {
 		// Since we couldn't store this image in database, let's remove the directory.
 		os.RemoveAll(newDir)
 		return err
 	}
 
+	return session.imageStore.UpdateMetadata(newID, func(metadata *reference.ImageMetadata) {
+		metadata.SourceDigest = img.SourceDigest
+		metadata.UploadedAt = time.Now()
+		metadata.UploadedBy = getUploader()
+		metadata.UploadedWith = strings.Join(os.Args, " ")
+	})
+}
+
+// getUploader Get the user uploading a stage. Uploads are done as root, so the user that ran sudo is preferred.
+func getUploader() string {
+	if sudoUser := os.Getenv("SUDO_USER"); len(sudoUser) > 0 {
+		return sudoUser
+	}
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return fmt.Sprintf("uid %d", os.Getuid())
+}
+
+// addTag Points a tag to the given stage id. If the tag is being overwritten,
+// the stage id it previously pointed to is kept as the previous version.
+// Pinned stages that would lose the tag (or their previous version) are unpinned if unpin is true, otherwise it's an error.
+func (session *Session) addTag(imageRef reference.ImageRef, id string, force bool, unpin bool) error {
+	existing, err := session.imageStore.Get(imageRef)
+	if err != nil && err != reference.ErrDoesNotExist {
+		return err
+	}
+	hasExisting := err == nil && existing.ID != id
+
+	if hasExisting && force {
+		err = session.releaseReplaced(imageRef, unpin)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = session.imageStore.AddTag(imageRef, id, force)
+	if err != nil {
+		return err
+	}
+
+	if hasExisting && session.config.KeepPrevious {
+		return session.previousStore.AddTag(imageRef, existing.ID, true)
+	}
+
 	return nil
 }
+
+// CheckReplaceable Returns an error if replacing the tag would replace or remove a pinned stage, and unpin isn't given.
+func (session *Session) CheckReplaceable(imageRef reference.ImageRef, unpin bool) error {
+	if unpin {
+		return nil
+	}
+	return session.releaseReplaced(imageRef, false)
+}
+
+// releaseReplaced Checks (and optionally unpins) the stages that lose a name when the tag is replaced:
+// the current stage, and the previous version if the current stage replaces it.
+func (session *Session) releaseReplaced(imageRef reference.ImageRef, unpin bool) error {
+	existing, err := session.imageStore.Get(imageRef)
+	if err == reference.ErrDoesNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = session.releasePinned(existing.ID, imageRef.FullName(), unpin)
+	if err != nil || !session.config.KeepPrevious {
+		return err
+	}
+
+	previous, err := session.previousStore.Get(imageRef)
+	if err == reference.ErrDoesNotExist || (err == nil && previous.ID == existing.ID) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return session.releasePinned(previous.ID, imageRef.FullName()+PreviousSuffix, unpin)
+}
@@ -0,0 +1,156 @@
+package staging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/docker/docker/pkg/ioutils"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// ErrNoDigests is returned when verifying an image that was staged before digests were recorded.
+var ErrNoDigests = fmt.Errorf("no digests recorded")
+
+// VerifyImage Recomputes the digests of every staged artifact and of image.json, and compares them
+// to the ones recorded when the image was uploaded. If the image has a dm-verity
+// hash tree, its root hash is also recomputed and compared.
+// Returns ErrNoDigests if the image has no recorded digests.
+func (session *Session) VerifyImage(stagedImage StagedImageNamed) error {
+	return verifyImageDir(stagedImage.StagedImage)
+}
+
+func verifyImageDir(image StagedImage) error {
+	if len(image.Digests) == 0 {
+		return ErrNoDigests
+	}
+
+	for _, artifact := range getImageArtifacts(image) {
+		expected, ok := image.Digests[artifact]
+		if !ok {
+			return fmt.Errorf("no digest recorded for %s", artifact)
+		}
+		actual, err := digestFile(path.Join(image.Dir, artifact))
+		if err != nil {
+			return err
+		}
+		if actual.String() != expected {
+			return fmt.Errorf("%s doesn't match its recorded digest", artifact)
+		}
+	}
+
+	// Images uploaded before image.json was digested only have the digests of their artifacts.
+	if expected, ok := image.Digests["image.json"]; ok {
+		actual, err := digestImageConfiguration(path.Join(image.Dir, "image.json"))
+		if err != nil {
+			return err
+		}
+		if actual.String() != expected {
+			return fmt.Errorf("image.json doesn't match its recorded digest")
+		}
+	}
+
+	return verifyVerityRootHash(image)
+}
+
+// recordDigests Computes the digests of every artifact in the image directory, and of image.json itself, and stores them in image.json.
+// Nothing else may be changed in image.json after this, or it will fail verification.
+func recordDigests(image StagedImage) error {
+	digests := make(map[string]string)
+	for _, artifact := range getImageArtifacts(image) {
+		d, err := digestFile(path.Join(image.Dir, artifact))
+		if err != nil {
+			return err
+		}
+		digests[artifact] = d.String()
+	}
+
+	imageConfiguration := path.Join(image.Dir, "image.json")
+	d, err := digestImageConfiguration(imageConfiguration)
+	if err != nil {
+		return err
+	}
+	digests["image.json"] = d.String()
+
+	return updateStagedImageConfiguration(imageConfiguration, map[string]interface{}{
+		"digests": digests,
+	})
+}
+
+// getImageArtifacts Gets the files of a staged image that are used to boot it.
+func getImageArtifacts(image StagedImage) []string {
+	result := []string{
+		image.Kernel,
+		image.InitRAMFS,
+		image.RootFS,
+	}
+	if len(image.VerityHashTree) > 0 {
+		result = append(result, image.VerityHashTree)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// digestImageConfiguration Computes the digest of an image.json, without the digests it stores.
+// The values are re-encoded with sorted keys, so that rewriting the file with the same values doesn't change its digest.
+func digestImageConfiguration(file string) (digest.Digest, error) {
+	jsonData, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	config := map[string]interface{}{}
+	err = json.Unmarshal(jsonData, &config)
+	if err != nil {
+		return "", err
+	}
+	delete(config, "digests")
+
+	jsonData, err = json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return digest.Canonical.FromBytes(jsonData), nil
+}
+
+func digestFile(file string) (digest.Digest, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return digest.Canonical.FromReader(f)
+}
+
+// updateStagedImageConfiguration Sets values in an image.json file,
+// leaving the values we don't know about untouched.
+func updateStagedImageConfiguration(file string, values map[string]interface{}) error {
+	jsonData, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	config := map[string]json.RawMessage{}
+	err = json.Unmarshal(jsonData, &config)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range values {
+		config[key], err = json.Marshal(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	jsonData, err = json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	return ioutils.AtomicWriteFile(file, jsonData, 0644)
+}
@@ -0,0 +1,123 @@
+package staging
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createImageDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "darch-verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"image.json":          `{"kernel":"vmlinuz-linux","initramfs":"initramfs-linux.img","rootfs":"rootfs.squash","custom":"value"}`,
+		"vmlinuz-linux":       "kernel",
+		"initramfs-linux.img": "initramfs",
+		"rootfs.squash":       "rootfs",
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestVerify(t *testing.T) {
+	dir := createImageDir(t)
+	defer os.RemoveAll(dir)
+
+	image, err := parseImageDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = verifyImageDir(image); err != ErrNoDigests {
+		t.Fatalf("expected ErrNoDigests, got %v", err)
+	}
+
+	if err = recordDigests(image); err != nil {
+		t.Fatal(err)
+	}
+
+	image, err = parseImageDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image.Digests) != 4 {
+		t.Fatalf("expected 4 digests, got %d", len(image.Digests))
+	}
+
+	// Values we don't know about should be preserved.
+	config, err := ioutil.ReadFile(path.Join(dir, "image.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(config), `"custom":"value"`) {
+		t.Fatal("unknown values in image.json were lost")
+	}
+
+	if err = verifyImageDir(image); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewriting image.json with the same values doesn't change its digest, changing a value does.
+	recorded, err := ioutil.ReadFile(path.Join(dir, "image.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = updateStagedImageConfiguration(path.Join(dir, "image.json"), map[string]interface{}{"custom": "value"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = verifyImageDir(image); err != nil {
+		t.Fatal(err)
+	}
+	if err = updateStagedImageConfiguration(path.Join(dir, "image.json"), map[string]interface{}{"kernelparams": "init=/bin/sh"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = verifyImageDir(image); err == nil {
+		t.Fatal("a changed image.json should have failed verification")
+	}
+	if err = ioutil.WriteFile(path.Join(dir, "image.json"), recorded, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(path.Join(dir, "rootfs.squash"), []byte("tampered"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = verifyImageDir(image); err == nil {
+		t.Fatal("tampered rootfs should have failed verification")
+	}
+}
+
+func TestCreationTimeSurvivesRewrites(t *testing.T) {
+	dir := createImageDir(t)
+	defer os.RemoveAll(dir)
+
+	createdAt := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := updateStagedImageConfiguration(path.Join(dir, "image.json"), map[string]interface{}{"createdat": createdAt}); err != nil {
+		t.Fatal(err)
+	}
+	image, err := parseImageDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = recordDigests(image); err != nil {
+		t.Fatal(err)
+	}
+
+	image, err = parseImageDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !image.CreationTime.Equal(createdAt) {
+		t.Fatalf("expected %v, got %v", createdAt, image.CreationTime)
+	}
+}
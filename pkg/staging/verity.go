@@ -0,0 +1,84 @@
+package staging
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// generateVerityHashTree Generates a dm-verity hash tree for the rootfs of the image,
+// and records it, and its root hash, in image.json.
+func generateVerityHashTree(image *StagedImage) error {
+	hashTree := image.RootFS + ".verity"
+
+	output, err := runVeritySetup("format", path.Join(image.Dir, image.RootFS), path.Join(image.Dir, hashTree))
+	if err != nil {
+		return err
+	}
+
+	rootHash := ""
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "Root hash:") {
+			rootHash = strings.TrimSpace(line[len("Root hash:"):])
+		}
+	}
+	if len(rootHash) == 0 {
+		return fmt.Errorf("veritysetup didn't return a root hash")
+	}
+
+	err = updateStagedImageConfiguration(path.Join(image.Dir, "image.json"), map[string]interface{}{
+		"verityhashtree": hashTree,
+		"verityroothash": rootHash,
+	})
+	if err != nil {
+		return err
+	}
+
+	image.VerityHashTree = hashTree
+	image.VerityRootHash = rootHash
+
+	return nil
+}
+
+// verifyVerityRootHash Recomputes the dm-verity root hash of the image's rootfs and
+// compares it against the recorded one.
+func verifyVerityRootHash(image StagedImage) error {
+	if len(image.VerityRootHash) == 0 {
+		return nil
+	}
+
+	_, err := runVeritySetup("verify",
+		path.Join(image.Dir, image.RootFS),
+		path.Join(image.Dir, image.VerityHashTree),
+		image.VerityRootHash)
+	if err != nil {
+		return fmt.Errorf("%s doesn't match its verity root hash: %v", image.RootFS, err)
+	}
+
+	return nil
+}
+
+// getVerityParams Get the kernel params the initramfs uses to check the rootfs of the image with dm-verity.
+// Returns an empty string if the image has no hash tree.
+func getVerityParams(image StagedImageNamed) string {
+	if len(image.VerityRootHash) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("darch_verity_hashtree=%s darch_verity_roothash=%s", image.VerityHashTree, image.VerityRootHash)
+}
+
+func runVeritySetup(args ...string) ([]byte, error) {
+	cmd := exec.Command("veritysetup", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return output, fmt.Errorf("error running veritysetup %s: %v %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
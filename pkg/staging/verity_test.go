@@ -0,0 +1,130 @@
+package staging
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// useFakeVeritySetup Puts a veritysetup on PATH that runs the given script, and records its arguments in the returned file.
+func useFakeVeritySetup(t *testing.T, script string) (string, func()) {
+	dir, err := ioutil.TempDir("", "darch-veritysetup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	argsFile := path.Join(dir, "args")
+	err = ioutil.WriteFile(path.Join(dir, "veritysetup"), []byte("#!/bin/sh\necho \"$@\" > "+argsFile+"\n"+script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousPath := os.Getenv("PATH")
+	os.Setenv("PATH", dir+":"+previousPath)
+	return argsFile, func() {
+		os.Setenv("PATH", previousPath)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGenerateVerityHashTree(t *testing.T) {
+	argsFile, cleanup := useFakeVeritySetup(t, `cat <<EOF
+VERITY header information for $3
+UUID:                   0b1f2d4e-6a0e-4d5c-9f4e-0c0a3b0d6f6a
+Hash type:              1
+Data blocks:            2
+Data block size:        4096
+Hash block size:        4096
+Hash algorithm:         sha256
+Salt:                   4c2b1f6c1e3d0b3c8f7d2e5a9b6c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c
+Root hash:              9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+EOF
+`)
+	defer cleanup()
+
+	dir := createImageDir(t)
+	defer os.RemoveAll(dir)
+
+	image, err := parseImageDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = generateVerityHashTree(&image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := ioutil.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedArgs := "format " + path.Join(dir, "rootfs.squash") + " " + path.Join(dir, "rootfs.squash.verity")
+	if strings.TrimSpace(string(args)) != expectedArgs {
+		t.Fatalf("expected veritysetup %s, got %s", expectedArgs, args)
+	}
+
+	const rootHash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	if image.VerityRootHash != rootHash || image.VerityHashTree != "rootfs.squash.verity" {
+		t.Fatalf("unexpected hash tree %s and root hash %s", image.VerityHashTree, image.VerityRootHash)
+	}
+
+	// The hash tree and root hash are recorded in image.json, without losing anything else.
+	config, err := loadStagedImageConfiguration(path.Join(dir, "image.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.VerityRootHash != rootHash || config.VerityHashTree != "rootfs.squash.verity" || config.Kernel != "vmlinuz-linux" {
+		t.Fatalf("unexpected image.json %v", config)
+	}
+}
+
+func TestGenerateVerityHashTreeNoRootHash(t *testing.T) {
+	_, cleanup := useFakeVeritySetup(t, "echo 'UUID: 0b1f2d4e'\n")
+	defer cleanup()
+
+	dir := createImageDir(t)
+	defer os.RemoveAll(dir)
+
+	image, err := parseImageDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = generateVerityHashTree(&image); err == nil {
+		t.Fatal("expected an error without a root hash")
+	}
+}
+
+func TestVerifyVerityRootHash(t *testing.T) {
+	argsFile, cleanup := useFakeVeritySetup(t, "echo 'Verification failed' >&2\nexit 1\n")
+	defer cleanup()
+
+	image := StagedImage{Dir: "/stage", RootFS: "rootfs.squash", VerityHashTree: "rootfs.squash.verity", VerityRootHash: "abc"}
+	err := verifyVerityRootHash(image)
+	if err == nil || !strings.Contains(err.Error(), "Verification failed") {
+		t.Fatalf("expected the verification to fail, got %v", err)
+	}
+	args, _ := ioutil.ReadFile(argsFile)
+	if strings.TrimSpace(string(args)) != "verify /stage/rootfs.squash /stage/rootfs.squash.verity abc" {
+		t.Fatalf("unexpected arguments %s", args)
+	}
+
+	// Images without a hash tree aren't checked.
+	if err = verifyVerityRootHash(StagedImage{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerityParams(t *testing.T) {
+	image := StagedImageNamed{StagedImage: StagedImage{RootFS: "rootfs.squash"}}
+	if params := getVerityParams(image); params != "" {
+		t.Fatalf("expected no params without a hash tree, got %s", params)
+	}
+
+	image.VerityHashTree = "rootfs.squash.verity"
+	image.VerityRootHash = "abc"
+	if params := getVerityParams(image); params != "darch_verity_hashtree=rootfs.squash.verity darch_verity_roothash=abc" {
+		t.Fatalf("unexpected params %s", params)
+	}
+}
@@ -91,9 +91,10 @@ arch-chroot rootfs mkdir -p /etc/containerd
 echo "root = \"/var/lib/darch/containerd\"" > rootfs/etc/containerd/config.toml
 arch-chroot rootfs systemctl enable containerd
 
-# Setup the fstab hooks for Darch
+# Enable the built-in hooks for Darch, with the fstab of this image
+mkdir -p rootfs/etc/darch/hooks
 cat rootfs/etc/fstab | tail -n +2 > rootfs/etc/darch/hooks/default_fstab
-echo "*=default_fstab" > rootfs/etc/darch/hooks/fstab.config
+echo '{"fstab": {"params": {"fstab": "default_fstab"}}, "hostname": {}, "machine-id": {}, "ssh": {}}' > rootfs/etc/darch/hooks/hooks-config.json
 
 # Run grub-mkconfig again to ensure it loads the Darch grub config file
 arch-chroot rootfs grub-mkconfig -o /boot/grub/grub.cfg